@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unknownAllocationKey groups holdings whose profile lacks the attribute.
+const unknownAllocationKey = "Unknown"
+
+// allocationKeys returns the attribute of a holding that an allocation is
+// grouped by.
+var allocationKeys = map[string]func(h PortfolioHolding) string{
+	"sector":   func(h PortfolioHolding) string { return h.Sector },
+	"industry": func(h PortfolioHolding) string { return h.Industry },
+	"country":  func(h PortfolioHolding) string { return h.Country },
+	"exchange": func(h PortfolioHolding) string { return h.Exchange },
+}
+
+// AllocationBucket is one group of an allocation breakdown. Weights are
+// percentages of the portfolio's total value and projected annual income.
+type AllocationBucket struct {
+	Key           string   `json:"key"`
+	HoldingsCount int      `json:"holdings_count"`
+	Value         float64  `json:"value"`
+	ValueWeight   float64  `json:"value_weight"`
+	AnnualIncome  float64  `json:"annual_income"`
+	IncomeWeight  float64  `json:"income_weight"`
+	Tickers       []string `json:"tickers"`
+}
+
+type PortfolioAllocation struct {
+	By           string             `json:"by"`
+	PortfolioID  string             `json:"portfolio_id,omitempty"`
+	TotalValue   float64            `json:"total_value"`
+	AnnualIncome float64            `json:"annual_income"`
+	Buckets      []AllocationBucket `json:"buckets"`
+}
+
+// computeAllocation groups holdings by the allocationKeys attribute named
+// by, largest value first.
+func computeAllocation(holdings []PortfolioHolding, by string) PortfolioAllocation {
+	keyOf := allocationKeys[by]
+	allocation := PortfolioAllocation{By: by, Buckets: []AllocationBucket{}}
+
+	byKey := map[string]*AllocationBucket{}
+	for _, h := range holdings {
+		key := keyOf(h)
+		if key == "" {
+			key = unknownAllocationKey
+		}
+		b, ok := byKey[key]
+		if !ok {
+			b = &AllocationBucket{Key: key}
+			byKey[key] = b
+		}
+		b.HoldingsCount++
+		b.Value += h.TotalValue
+		b.AnnualIncome += h.MonthlyDividend * 12
+		b.Tickers = append(b.Tickers, h.Ticker)
+
+		allocation.TotalValue += h.TotalValue
+		allocation.AnnualIncome += h.MonthlyDividend * 12
+	}
+
+	for _, b := range byKey {
+		if allocation.TotalValue > 0 {
+			b.ValueWeight = roundTo(b.Value/allocation.TotalValue*100, 2)
+		}
+		if allocation.AnnualIncome > 0 {
+			b.IncomeWeight = roundTo(b.AnnualIncome/allocation.AnnualIncome*100, 2)
+		}
+		b.Value = roundTo(b.Value, 2)
+		b.AnnualIncome = roundTo(b.AnnualIncome, 2)
+		sort.Strings(b.Tickers)
+		allocation.Buckets = append(allocation.Buckets, *b)
+	}
+	sort.Slice(allocation.Buckets, func(i, j int) bool {
+		if allocation.Buckets[i].Value != allocation.Buckets[j].Value {
+			return allocation.Buckets[i].Value > allocation.Buckets[j].Value
+		}
+		return allocation.Buckets[i].Key < allocation.Buckets[j].Key
+	})
+
+	allocation.TotalValue = roundTo(allocation.TotalValue, 2)
+	allocation.AnnualIncome = roundTo(allocation.AnnualIncome, 2)
+	return allocation
+}
+
+// getRawScopedHoldings returns the holdings of one portfolio, or of all
+// the user's portfolios when portfolioID is empty, as stored.
+func getRawScopedHoldings(userID, portfolioID string) ([]PortfolioHolding, error) {
+	if portfolioID == "" {
+		return getHoldings(userID)
+	}
+	return getPortfolioHoldings(portfolioID, userID)
+}
+
+// getScopedHoldings returns the same holdings converted into the user's
+// base currency, so they can be added up.
+func getScopedHoldings(userID, portfolioID string) ([]PortfolioHolding, error) {
+	holdings, _, err := getBaseHoldings(userID, portfolioID, "")
+	return holdings, err
+}
+
+func registerAllocationRoutes(protected *gin.RouterGroup) {
+	protected.GET("/allocation", func(c *gin.Context) {
+		by := c.DefaultQuery("by", "sector")
+		if _, ok := allocationKeys[by]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "by must be one of sector, industry, country or exchange"})
+			return
+		}
+
+		portfolioID := c.Query("portfolio_id")
+		holdings, err := getScopedHoldings(c.GetString("user_id"), portfolioID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		allocation := computeAllocation(holdings, by)
+		allocation.PortfolioID = portfolioID
+		c.JSON(http.StatusOK, allocation)
+	})
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultBenchmarkSymbol = "SPY"
+
+// BenchmarkPoint compares the real portfolio with the benchmark on one date.
+// Income is trailing-twelve-month dividends received; yield is that income
+// over the value on the date.
+type BenchmarkPoint struct {
+	Date            string  `json:"date"`
+	PortfolioValue  float64 `json:"portfolio_value"`
+	BenchmarkValue  float64 `json:"benchmark_value"`
+	PortfolioIncome float64 `json:"portfolio_income"`
+	BenchmarkIncome float64 `json:"benchmark_income"`
+	PortfolioYield  float64 `json:"portfolio_yield"`
+	BenchmarkYield  float64 `json:"benchmark_yield"`
+}
+
+// Outperformance is portfolio minus benchmark; positive means the portfolio
+// did better. Return figures are percentage points.
+type Outperformance struct {
+	EndValue  float64  `json:"end_value"`
+	TotalGain float64  `json:"total_gain"`
+	Dividends float64  `json:"dividends"`
+	TWR       float64  `json:"twr"`
+	MWR       *float64 `json:"mwr"`
+}
+
+// BenchmarkComparison is in the base currency; Rates are those used to
+// convert the ledger and both sides' prices and dividends.
+type BenchmarkComparison struct {
+	Symbol         string            `json:"symbol"`
+	From           string            `json:"from"`
+	To             string            `json:"to"`
+	Interval       string            `json:"interval"`
+	PortfolioID    string            `json:"portfolio_id,omitempty"`
+	BaseCurrency   string            `json:"base_currency"`
+	Rates          []FXRate          `json:"rates"`
+	Series         []BenchmarkPoint  `json:"series"`
+	Portfolio      PerformanceResult `json:"portfolio"`
+	Benchmark      PerformanceResult `json:"benchmark"`
+	Outperformance Outperformance    `json:"outperformance"`
+}
+
+// simulateBenchmark builds a ledger for a portfolio that held only symbol
+// and received exactly the same external cash flows as actual: its starting
+// value is invested at from, every buy and sell is mirrored by a benchmark
+// trade of the same amount, and the benchmark's own dividends are received
+// as cash. Sells are capped at the benchmark shares held.
+func simulateBenchmark(actual *ledger, symbol string, prices priceSeries, dividends []DividendEvent, from, to time.Time) *ledger {
+	fromDate, toDate := from.Format(dateLayout), to.Format(dateLayout)
+	bench := &ledger{prices: map[string]priceSeries{symbol: prices}}
+
+	var shares float64
+	trade := func(txType, date string, amount float64) {
+		price, ok := prices.closeOn(date)
+		if !ok || price <= 0 || amount <= 0 {
+			return
+		}
+		n := amount / price
+		if txType == TransactionSell {
+			if n > shares {
+				n = shares
+			}
+			shares -= n
+		} else {
+			shares += n
+		}
+		if n > 0 {
+			bench.transactions = append(bench.transactions, Transaction{
+				Ticker: symbol, Type: txType, TradeDate: date, Shares: n, Price: price, Amount: n * price,
+			})
+		}
+	}
+
+	trade(TransactionBuy, fromDate, actual.value(fromDate, true))
+
+	// Walk real trades and benchmark ex-dates together in date order
+	d := 0
+	payDividends := func(through string) {
+		for ; d < len(dividends) && dividends[d].ExDate <= through; d++ {
+			e := dividends[d]
+			if e.ExDate <= fromDate || shares <= 0 {
+				continue
+			}
+			payDate := e.PaymentDate
+			if payDate == "" || payDate < e.ExDate {
+				payDate = e.ExDate
+			}
+			if payDate > toDate {
+				continue
+			}
+			// Closes are split-adjusted, so the share count is too
+			perShare := e.AdjAmount
+			if perShare == 0 {
+				perShare = e.Amount
+			}
+			bench.transactions = append(bench.transactions, Transaction{
+				Ticker: symbol, Type: TransactionDividend, TradeDate: payDate, Amount: shares * perShare,
+			})
+		}
+	}
+
+	for _, t := range actual.transactions {
+		if t.TradeDate <= fromDate || t.TradeDate > toDate {
+			continue
+		}
+		if t.Type != TransactionBuy && t.Type != TransactionSell {
+			continue
+		}
+		// Dividends going ex on or before the trade date are paid on the old
+		// share count: buying on the ex-date does not earn the dividend
+		payDividends(t.TradeDate)
+		trade(t.Type, t.TradeDate, t.Amount)
+	}
+	payDividends(toDate)
+
+	sort.SliceStable(bench.transactions, func(i, j int) bool {
+		return bench.transactions[i].TradeDate < bench.transactions[j].TradeDate
+	})
+	return bench
+}
+
+// seriesDates returns from, every step after it and to.
+func seriesDates(from, to time.Time, interval string) []string {
+	var dates []string
+	for d := from; d.Before(to); {
+		dates = append(dates, d.Format(dateLayout))
+		switch interval {
+		case "day":
+			d = d.AddDate(0, 0, 1)
+		case "month":
+			d = d.AddDate(0, 1, 0)
+		default:
+			d = d.AddDate(0, 0, 7)
+		}
+	}
+	return append(dates, to.Format(dateLayout))
+}
+
+func ledgerPoint(l *ledger, date string) (value, income, yield float64) {
+	value = l.value(date, true)
+	t, _ := time.Parse(dateLayout, date)
+	income = l.dividendsBetween(t.AddDate(-1, 0, 0).Format(dateLayout), date)
+	if value > 0 {
+		yield = income / value * 100
+	}
+	return roundTo(value, 2), roundTo(income, 2), roundTo(yield, 2)
+}
+
+func getBenchmarkComparison(userID, portfolioID, symbol string, from, to time.Time, interval, apiKey string) (*BenchmarkComparison, error) {
+	actual, lfx, err := loadLedger(userID, portfolioID, from, to, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	rate, err := lfx.rate(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	bars, err := getPriceHistory(symbol, from.AddDate(0, 0, -priceLookback), to, apiKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load benchmark prices: %v", err)
+	}
+	if len(bars) == 0 {
+		return nil, fmt.Errorf("no price history found for benchmark %s", symbol)
+	}
+	dividends, err := getDividendHistory(symbol, apiKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load benchmark dividends: %v", err)
+	}
+
+	converted := make([]DividendEvent, len(dividends))
+	for i, e := range dividends {
+		e.Amount, e.AdjAmount = e.Amount*rate, e.AdjAmount*rate
+		converted[i] = e
+	}
+	bench := simulateBenchmark(actual, symbol, newPriceSeries(bars).scale(rate), converted, from, to)
+
+	comparison := &BenchmarkComparison{
+		Symbol:       symbol,
+		From:         from.Format(dateLayout),
+		To:           to.Format(dateLayout),
+		Interval:     interval,
+		PortfolioID:  portfolioID,
+		BaseCurrency: lfx.conv.base,
+		Rates:        lfx.conv.used,
+		Portfolio:    computePerformance(actual, from, to),
+		Benchmark:    computePerformance(bench, from, to),
+	}
+
+	for _, date := range seriesDates(from, to, interval) {
+		p := BenchmarkPoint{Date: date}
+		p.PortfolioValue, p.PortfolioIncome, p.PortfolioYield = ledgerPoint(actual, date)
+		p.BenchmarkValue, p.BenchmarkIncome, p.BenchmarkYield = ledgerPoint(bench, date)
+		comparison.Series = append(comparison.Series, p)
+	}
+
+	comparison.Outperformance = Outperformance{
+		EndValue:  roundTo(comparison.Portfolio.EndValue-comparison.Benchmark.EndValue, 2),
+		TotalGain: roundTo(comparison.Portfolio.TotalGain-comparison.Benchmark.TotalGain, 2),
+		Dividends: roundTo(comparison.Portfolio.Dividends-comparison.Benchmark.Dividends, 2),
+		TWR:       roundTo(comparison.Portfolio.TWR.Total-comparison.Benchmark.TWR.Total, 2),
+	}
+	if comparison.Portfolio.MWR != nil && comparison.Benchmark.MWR != nil {
+		mwr := roundTo(comparison.Portfolio.MWR.Total-comparison.Benchmark.MWR.Total, 2)
+		comparison.Outperformance.MWR = &mwr
+	}
+
+	return comparison, nil
+}
+
+func registerBenchmarkRoutes(protected *gin.RouterGroup, apiKey string) {
+	protected.GET("/benchmark", func(c *gin.Context) {
+		from, to, err := parseDateRange(c, 365*24*time.Hour)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		interval := c.DefaultQuery("interval", "week")
+		if _, ok := historyIntervals[interval]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be one of day, week or month"})
+			return
+		}
+
+		symbol, err := normalizeSymbol(c.DefaultQuery("symbol", defaultBenchmarkSymbol))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		comparison, err := getBenchmarkComparison(c.GetString("user_id"), c.Query("portfolio_id"), symbol, from, to, interval, apiKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, comparison)
+	})
+}
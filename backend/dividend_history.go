@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// dividendHistoryMaxAge is how long stored dividend history is used before
+// it is fetched from the provider again. Dividends are declared weeks ahead,
+// so a daily refresh is plenty.
+const dividendHistoryMaxAge = 24 * time.Hour
+
+// storeDividendHistory replaces the stored history for symbol.
+func storeDividendHistory(symbol string, events []DividendEvent) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin dividend transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO dividend_history (symbol, ex_date, amount, adj_amount, record_date, payment_date, declaration_date)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::date, NULLIF($6, '')::date, NULLIF($7, '')::date)
+		ON CONFLICT (symbol, ex_date) DO UPDATE
+		SET amount = EXCLUDED.amount, adj_amount = EXCLUDED.adj_amount, record_date = EXCLUDED.record_date,
+			payment_date = EXCLUDED.payment_date, declaration_date = EXCLUDED.declaration_date
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare dividend insert: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, e := range events {
+		if _, err := stmt.Exec(symbol, e.ExDate, e.Amount, e.AdjAmount, e.RecordDate, e.PaymentDate, e.DeclarationDate); err != nil {
+			return fmt.Errorf("failed to insert dividend for %s on %s: %v", symbol, e.ExDate, err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO dividend_history_fetches (symbol, fetched_at) VALUES ($1, NOW())
+		ON CONFLICT (symbol) DO UPDATE SET fetched_at = NOW()
+	`, symbol)
+	if err != nil {
+		return fmt.Errorf("failed to record dividend fetch: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// getDividendHistory returns symbol's dividends oldest first, refreshing the
+// stored copy from the provider when it is older than dividendHistoryMaxAge.
+func getDividendHistory(symbol string, apiKey string) ([]DividendEvent, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot load dividend history")
+	}
+
+	var fetchedAt time.Time
+	err := db.QueryRow("SELECT fetched_at FROM dividend_history_fetches WHERE symbol = $1", symbol).Scan(&fetchedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to load dividend fetch time: %v", err)
+	}
+
+	if err == sql.ErrNoRows || time.Since(fetchedAt) > dividendHistoryMaxAge {
+		events, err := fetchFMPDividendHistory(symbol, apiKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := storeDividendHistory(symbol, events); err != nil {
+			return nil, err
+		}
+	}
+
+	return getStoredDividendHistory(symbol)
+}
+
+// getStoredDividendHistory returns the dividends stored for symbol, oldest
+// first, without contacting the provider.
+func getStoredDividendHistory(symbol string) ([]DividendEvent, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot load dividend history")
+	}
+
+	rows, err := db.Query(`
+		SELECT ex_date, amount, adj_amount, record_date, payment_date, declaration_date
+		FROM dividend_history
+		WHERE symbol = $1
+		ORDER BY ex_date
+	`, symbol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dividend history: %v", err)
+	}
+	defer rows.Close()
+
+	events := []DividendEvent{}
+	for rows.Next() {
+		var e DividendEvent
+		var exDate time.Time
+		var recordDate, paymentDate, declarationDate sql.NullTime
+		if err := rows.Scan(&exDate, &e.Amount, &e.AdjAmount, &recordDate, &paymentDate, &declarationDate); err != nil {
+			return nil, fmt.Errorf("failed to scan dividend: %v", err)
+		}
+		e.ExDate = exDate.Format(dateLayout)
+		if recordDate.Valid {
+			e.RecordDate = recordDate.Time.Format(dateLayout)
+		}
+		if paymentDate.Valid {
+			e.PaymentDate = paymentDate.Time.Format(dateLayout)
+		}
+		if declarationDate.Valid {
+			e.DeclarationDate = declarationDate.Time.Format(dateLayout)
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// annualDividends totals split-adjusted dividends per calendar year of the
+// ex-date.
+func annualDividends(events []DividendEvent) map[int]float64 {
+	totals := map[int]float64{}
+	for _, e := range events {
+		t, err := time.Parse(dateLayout, e.ExDate)
+		if err != nil {
+			continue
+		}
+		amount := e.AdjAmount
+		if amount == 0 {
+			amount = e.Amount
+		}
+		totals[t.Year()] += amount
+	}
+	return totals
+}
+
+// dividendCAGR returns the compound annual growth rate, in percent, of
+// calendar-year dividends over the given number of years ending with
+// endYear. ok is false when either end year paid nothing.
+func dividendCAGR(events []DividendEvent, endYear, years int) (cagr float64, ok bool) {
+	totals := annualDividends(events)
+	start, end := totals[endYear-years], totals[endYear]
+	if years <= 0 || start <= 0 || end <= 0 {
+		return 0, false
+	}
+	return (math.Pow(end/start, 1/float64(years)) - 1) * 100, true
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportSchemaVersion is bumped whenever export columns are renamed,
+// removed or reordered. New columns are only ever appended.
+const exportSchemaVersion = 1
+
+// Export tables. CSV exports carry one table; JSON and XLSX carry all of
+// them.
+const (
+	exportHoldings     = "holdings"
+	exportTransactions = "transactions"
+	exportDividends    = "dividends"
+)
+
+// exportTable is a table in its export column order.
+type exportTable struct {
+	Name    string
+	Columns []string
+	Rows    [][]interface{}
+}
+
+var (
+	holdingExportColumns = []string{
+		"id", "portfolio_id", "portfolio_name", "ticker", "company", "shares", "current_price",
+		"dividend_yield", "total_value", "monthly_dividend", "sector", "industry", "country",
+		"exchange", "currency", "created_at", "updated_at", "dividend_currency",
+		"security_type", "expense_ratio",
+	}
+	transactionExportColumns = []string{
+		"id", "portfolio_id", "ticker", "type", "trade_date", "shares", "price", "fees",
+		"amount", "notes", "created_at",
+	}
+	dividendExportColumns = []string{
+		"symbol", "ex_date", "amount", "adj_amount", "record_date", "payment_date", "declaration_date",
+	}
+)
+
+// ExportedDividend is a stored dividend event with its symbol.
+type ExportedDividend struct {
+	Symbol string `json:"symbol"`
+	DividendEvent
+}
+
+// PortfolioExport is everything a user holds. Transactions and dividend
+// history are empty when none are recorded; dividend history is what has
+// already been fetched for the held symbols.
+type PortfolioExport struct {
+	SchemaVersion   int                `json:"schema_version"`
+	ExportedAt      time.Time          `json:"exported_at"`
+	PortfolioID     string             `json:"portfolio_id,omitempty"`
+	Holdings        []PortfolioHolding `json:"holdings"`
+	Transactions    []Transaction      `json:"transactions"`
+	DividendHistory []ExportedDividend `json:"dividend_history"`
+
+	portfolioNames map[string]string
+}
+
+func getPortfolioExport(userID, portfolioID string) (*PortfolioExport, error) {
+	export := &PortfolioExport{
+		SchemaVersion:  exportSchemaVersion,
+		ExportedAt:     time.Now().UTC(),
+		PortfolioID:    portfolioID,
+		portfolioNames: map[string]string{},
+	}
+
+	portfolios, err := getPortfolios(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range portfolios {
+		export.portfolioNames[p.ID] = p.Name
+	}
+
+	export.Holdings, err = getRawScopedHoldings(userID, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(export.Holdings, func(i, j int) bool {
+		a, b := export.Holdings[i], export.Holdings[j]
+		if a.PortfolioID != b.PortfolioID {
+			return export.portfolioNames[a.PortfolioID] < export.portfolioNames[b.PortfolioID]
+		}
+		return a.Ticker < b.Ticker
+	})
+
+	export.Transactions, err = getTransactions(userID, TransactionFilter{PortfolioID: portfolioID})
+	if err != nil {
+		return nil, err
+	}
+
+	export.DividendHistory = []ExportedDividend{}
+	for _, p := range aggregatePositions(export.Holdings) {
+		events, err := getStoredDividendHistory(p.Ticker)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range events {
+			export.DividendHistory = append(export.DividendHistory, ExportedDividend{Symbol: p.Ticker, DividendEvent: e})
+		}
+	}
+	sort.SliceStable(export.DividendHistory, func(i, j int) bool {
+		return export.DividendHistory[i].Symbol < export.DividendHistory[j].Symbol
+	})
+
+	return export, nil
+}
+
+func exportTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (e *PortfolioExport) table(name string) exportTable {
+	switch name {
+	case exportTransactions:
+		t := exportTable{Name: name, Columns: transactionExportColumns}
+		for _, tx := range e.Transactions {
+			t.Rows = append(t.Rows, []interface{}{
+				tx.ID, tx.PortfolioID, tx.Ticker, tx.Type, tx.TradeDate, tx.Shares, tx.Price, tx.Fees,
+				tx.Amount, tx.Notes, exportTime(tx.CreatedAt),
+			})
+		}
+		return t
+	case exportDividends:
+		t := exportTable{Name: name, Columns: dividendExportColumns}
+		for _, d := range e.DividendHistory {
+			t.Rows = append(t.Rows, []interface{}{
+				d.Symbol, d.ExDate, d.Amount, d.AdjAmount, d.RecordDate, d.PaymentDate, d.DeclarationDate,
+			})
+		}
+		return t
+	default:
+		t := exportTable{Name: exportHoldings, Columns: holdingExportColumns}
+		for _, h := range e.Holdings {
+			t.Rows = append(t.Rows, []interface{}{
+				h.ID, h.PortfolioID, e.portfolioNames[h.PortfolioID], h.Ticker, h.Company, h.Shares, h.CurrentPrice,
+				h.DividendYield, h.TotalValue, h.MonthlyDividend, h.Sector, h.Industry, h.Country,
+				h.Exchange, h.Currency, exportTime(h.CreatedAt), exportTime(h.UpdatedAt), h.DividendCurrency,
+				h.SecurityType, exportOptional(h.ExpenseRatio),
+			})
+		}
+		return t
+	}
+}
+
+// exportOptional leaves missing values blank.
+func exportOptional(v *float64) interface{} {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
+
+// exportCell formats a CSV cell. Text starting with a formula character is
+// prefixed with a quote so spreadsheets show it rather than evaluate it.
+func exportCell(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+			return "'" + v
+		}
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func registerExportRoutes(protected *gin.RouterGroup) {
+	protected.GET("/export", func(c *gin.Context) {
+		format := strings.ToLower(c.DefaultQuery("format", "json"))
+		table := strings.ToLower(c.DefaultQuery("table", exportHoldings))
+
+		if format != "csv" && format != "json" && format != "xlsx" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv, json or xlsx"})
+			return
+		}
+		if table != exportHoldings && table != exportTransactions && table != exportDividends {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "table must be holdings, transactions or dividends"})
+			return
+		}
+
+		export, err := getPortfolioExport(c.GetString("user_id"), c.Query("portfolio_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		filename := "dividend-tracker-" + export.ExportedAt.Format("20060102")
+		c.Header("X-Export-Schema-Version", fmt.Sprint(exportSchemaVersion))
+
+		switch format {
+		case "json":
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+			c.Header("Content-Type", "application/json; charset=utf-8")
+			c.Status(http.StatusOK)
+			enc := json.NewEncoder(c.Writer)
+			enc.SetIndent("", "  ")
+			enc.Encode(export)
+
+		case "csv":
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.csv"`, filename, table))
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			c.Status(http.StatusOK)
+			t := export.table(table)
+			w := csv.NewWriter(c.Writer)
+			w.Write(t.Columns)
+			for _, row := range t.Rows {
+				record := make([]string, len(row))
+				for i, v := range row {
+					record[i] = exportCell(v)
+				}
+				w.Write(record)
+			}
+			w.Flush()
+
+		case "xlsx":
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, filename))
+			c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+			c.Status(http.StatusOK)
+			sheets := []xlsxSheet{{
+				Name: "About",
+				Rows: [][]interface{}{
+					{"schema_version", exportSchemaVersion},
+					{"exported_at", exportTime(export.ExportedAt)},
+					{"portfolio_id", export.PortfolioID},
+				},
+			}}
+			for _, name := range []string{exportHoldings, exportTransactions, exportDividends} {
+				t := export.table(name)
+				if name != exportHoldings && len(t.Rows) == 0 {
+					continue
+				}
+				rows := [][]interface{}{{}}
+				for _, col := range t.Columns {
+					rows[0] = append(rows[0], col)
+				}
+				sheets = append(sheets, xlsxSheet{Name: strings.ToUpper(name[:1]) + name[1:], Rows: append(rows, t.Rows...)})
+			}
+			if err := writeXLSX(c.Writer, sheets); err != nil {
+				c.Error(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Security types of a holding. ETFs and mutual funds are flagged by the
+// provider's profile; closed-end funds are recognised by name and can
+// always be set by hand.
+const (
+	SecurityStock         = "stock"
+	SecurityETF           = "etf"
+	SecurityMutualFund    = "mutual_fund"
+	SecurityClosedEndFund = "closed_end_fund"
+)
+
+func isFundType(securityType string) bool {
+	return securityType == SecurityETF || securityType == SecurityMutualFund || securityType == SecurityClosedEndFund
+}
+
+// classifySecurity derives the security type from a company profile.
+// FMP has no closed-end fund flag, so asset-management listings named as
+// a fund or trust are taken to be one.
+func classifySecurity(profile *CompanyProfile) string {
+	switch {
+	case profile.IsEtf:
+		return SecurityETF
+	case profile.IsFund:
+		return SecurityMutualFund
+	case strings.Contains(profile.Industry, "Asset Management"):
+		name := strings.ToLower(profile.CompanyName)
+		if strings.Contains(name, "fund") || strings.HasSuffix(name, "trust") {
+			return SecurityClosedEndFund
+		}
+	}
+	return SecurityStock
+}
+
+// fetchFMPExpenseRatio returns a fund's expense ratio in percent, or nil
+// when FMP has none.
+func fetchFMPExpenseRatio(symbol, apiKey string) (*float64, error) {
+	url := fmt.Sprintf("https://financialmodelingprep.com/api/v4/etf-info?symbol=%s&apikey=%s", symbol, apiKey)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch fund info from FMP: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("FMP fund info API returned status %d", resp.StatusCode)
+	}
+
+	var fmpResp []struct {
+		Symbol       string   `json:"symbol"`
+		ExpenseRatio *float64 `json:"expenseRatio"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&fmpResp); err != nil {
+		return nil, fmt.Errorf("failed to parse FMP fund info response: %v", err)
+	}
+	if len(fmpResp) == 0 {
+		return nil, nil
+	}
+	return fmpResp[0].ExpenseRatio, nil
+}
+
+// FundDistribution splits one distribution, per share, into its sources
+// as reported by the fund (for example in a Section 19a notice or on the
+// 1099-DIV).
+type FundDistribution struct {
+	ExDate          string  `json:"ex_date" binding:"required"`
+	Income          float64 `json:"income" binding:"min=0"`
+	ShortTermGain   float64 `json:"short_term_gain" binding:"min=0"`
+	LongTermGain    float64 `json:"long_term_gain" binding:"min=0"`
+	ReturnOfCapital float64 `json:"return_of_capital" binding:"min=0"`
+}
+
+func (d FundDistribution) total() float64 {
+	return d.Income + d.ShortTermGain + d.LongTermGain + d.ReturnOfCapital
+}
+
+type FundDistributionsRequest struct {
+	Distributions []FundDistribution `json:"distributions" binding:"dive"`
+}
+
+type HoldingSecurityTypeRequest struct {
+	SecurityType string `json:"security_type" binding:"required,oneof=stock etf mutual_fund closed_end_fund"`
+}
+
+// getFundDistributions returns the user's distribution breakdowns, oldest
+// first, for one ticker or, with an empty ticker, all of them.
+func getFundDistributions(userID, ticker string) ([]FundDistribution, map[string][]FundDistribution, error) {
+	if db == nil {
+		return nil, nil, fmt.Errorf("database unavailable - cannot load fund distributions")
+	}
+
+	rows, err := db.Query(`
+		SELECT ticker, ex_date, income, short_term_gain, long_term_gain, return_of_capital
+		FROM fund_distributions
+		WHERE user_id = $1 AND ($2 = '' OR ticker = $2)
+		ORDER BY ticker, ex_date
+	`, userID, ticker)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query fund distributions: %v", err)
+	}
+	defer rows.Close()
+
+	all := []FundDistribution{}
+	byTicker := map[string][]FundDistribution{}
+	for rows.Next() {
+		var t string
+		var d FundDistribution
+		var exDate time.Time
+		if err := rows.Scan(&t, &exDate, &d.Income, &d.ShortTermGain, &d.LongTermGain, &d.ReturnOfCapital); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan fund distribution: %v", err)
+		}
+		d.ExDate = exDate.Format(dateLayout)
+		all = append(all, d)
+		byTicker[t] = append(byTicker[t], d)
+	}
+	return all, byTicker, rows.Err()
+}
+
+// replaceFundDistributions swaps the breakdowns stored for a ticker.
+func replaceFundDistributions(userID, ticker string, distributions []FundDistribution) error {
+	if db == nil {
+		return fmt.Errorf("database unavailable - cannot save fund distributions")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin fund distributions transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM fund_distributions WHERE user_id = $1 AND ticker = $2", userID, ticker); err != nil {
+		return fmt.Errorf("failed to clear fund distributions: %v", err)
+	}
+	for _, d := range distributions {
+		_, err := tx.Exec(`
+			INSERT INTO fund_distributions (user_id, ticker, ex_date, income, short_term_gain, long_term_gain, return_of_capital, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		`, userID, ticker, d.ExDate, d.Income, d.ShortTermGain, d.LongTermGain, d.ReturnOfCapital)
+		if err != nil {
+			return fmt.Errorf("failed to insert fund distribution for %s on %s: %v", ticker, d.ExDate, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DistributionBreakdown totals a fund's trailing twelve months of
+// distributions per share by source. Unclassified is the part of
+// distributions with no stored breakdown.
+type DistributionBreakdown struct {
+	Total           float64 `json:"total"`
+	Income          float64 `json:"income"`
+	ShortTermGain   float64 `json:"short_term_gain"`
+	LongTermGain    float64 `json:"long_term_gain"`
+	ReturnOfCapital float64 `json:"return_of_capital"`
+	Unclassified    float64 `json:"unclassified"`
+}
+
+// trailingBreakdown splits the provider's distributions with an ex-date
+// after since. A stored breakdown is matched by ex-date and scaled to the
+// split-adjusted amount, so the parts always add up to the distributions
+// behind dividend_yield.
+func trailingBreakdown(events []DividendEvent, breakdowns []FundDistribution, since time.Time) (b DistributionBreakdown, classified, count int) {
+	byDate := map[string]FundDistribution{}
+	for _, d := range breakdowns {
+		byDate[d.ExDate] = d
+	}
+
+	for _, e := range events {
+		exDate, err := time.Parse(dateLayout, e.ExDate)
+		if err != nil || !exDate.After(since) {
+			continue
+		}
+		count++
+		b.Total += e.AdjAmount
+		d, ok := byDate[e.ExDate]
+		if !ok || d.total() <= 0 {
+			b.Unclassified += e.AdjAmount
+			continue
+		}
+		classified++
+		scale := e.AdjAmount / d.total()
+		b.Income += d.Income * scale
+		b.ShortTermGain += d.ShortTermGain * scale
+		b.LongTermGain += d.LongTermGain * scale
+		b.ReturnOfCapital += d.ReturnOfCapital * scale
+	}
+
+	b.Total, b.Income = roundTo(b.Total, 4), roundTo(b.Income, 4)
+	b.ShortTermGain, b.LongTermGain = roundTo(b.ShortTermGain, 4), roundTo(b.LongTermGain, 4)
+	b.ReturnOfCapital, b.Unclassified = roundTo(b.ReturnOfCapital, 4), roundTo(b.Unclassified, 4)
+	return b, classified, count
+}
+
+// FundHolding breaks a fund's distribution yield (dividend_yield on the
+// holding) down by source. Unclassified distributions count as income, so
+// without breakdowns IncomeYield equals DistributionYield.
+type FundHolding struct {
+	HoldingID               string                `json:"holding_id"`
+	PortfolioID             string                `json:"portfolio_id"`
+	Ticker                  string                `json:"ticker"`
+	Company                 string                `json:"company"`
+	SecurityType            string                `json:"security_type"`
+	ExpenseRatio            *float64              `json:"expense_ratio"`
+	DistributionYield       float64               `json:"distribution_yield"`
+	IncomeYield             float64               `json:"income_yield"`
+	CapitalGainsYield       float64               `json:"capital_gains_yield"`
+	ReturnOfCapitalYield    float64               `json:"return_of_capital_yield"`
+	TrailingDistributions   DistributionBreakdown `json:"trailing_distributions"`
+	Distributions           int                   `json:"distributions"`
+	ClassifiedDistributions int                   `json:"classified_distributions"`
+}
+
+type FundReport struct {
+	Funds    []FundHolding `json:"funds"`
+	Warnings []string      `json:"warnings"`
+}
+
+// getFundReport covers the fund holdings in scope, plus any other holding
+// with stored distribution breakdowns.
+func getFundReport(userID, portfolioID, apiKey string) (*FundReport, error) {
+	holdings, err := getRawScopedHoldings(userID, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+	_, breakdowns, err := getFundDistributions(userID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FundReport{Funds: []FundHolding{}, Warnings: []string{}}
+	since := time.Now().AddDate(-1, 0, 0)
+	events := map[string][]DividendEvent{}
+	for _, h := range holdings {
+		if !isFundType(h.SecurityType) && len(breakdowns[h.Ticker]) == 0 {
+			continue
+		}
+
+		if _, ok := events[h.Ticker]; !ok {
+			e, err := getDividendHistory(h.Ticker, apiKey)
+			if err != nil {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("no dividend history for %s: %v", h.Ticker, err))
+			}
+			events[h.Ticker] = e
+		}
+
+		b, classified, count := trailingBreakdown(events[h.Ticker], breakdowns[h.Ticker], since)
+		f := FundHolding{
+			HoldingID:               h.ID,
+			PortfolioID:             h.PortfolioID,
+			Ticker:                  h.Ticker,
+			Company:                 h.Company,
+			SecurityType:            h.SecurityType,
+			ExpenseRatio:            h.ExpenseRatio,
+			DistributionYield:       h.DividendYield,
+			IncomeYield:             h.DividendYield,
+			TrailingDistributions:   b,
+			Distributions:           count,
+			ClassifiedDistributions: classified,
+		}
+		if b.Total > 0 {
+			share := h.DividendYield / b.Total
+			f.IncomeYield = roundTo((b.Income+b.Unclassified)*share, 2)
+			f.CapitalGainsYield = roundTo((b.ShortTermGain+b.LongTermGain)*share, 2)
+			f.ReturnOfCapitalYield = roundTo(b.ReturnOfCapital*share, 2)
+		}
+		report.Funds = append(report.Funds, f)
+	}
+
+	sort.SliceStable(report.Funds, func(i, j int) bool { return report.Funds[i].Ticker < report.Funds[j].Ticker })
+	return report, nil
+}
+
+func registerFundRoutes(protected *gin.RouterGroup, apiKey string) {
+	protected.GET("/funds", func(c *gin.Context) {
+		report, err := getFundReport(c.GetString("user_id"), c.Query("portfolio_id"), apiKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+
+	protected.GET("/funds/:ticker/distributions", func(c *gin.Context) {
+		ticker, err := normalizeSymbol(c.Param("ticker"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		distributions, _, err := getFundDistributions(c.GetString("user_id"), ticker)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, distributions)
+	})
+
+	protected.PUT("/funds/:ticker/distributions", func(c *gin.Context) {
+		ticker, err := normalizeSymbol(c.Param("ticker"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var req FundDistributionsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		distributions := req.Distributions
+		if distributions == nil {
+			distributions = []FundDistribution{}
+		}
+		seen := map[string]bool{}
+		for _, d := range distributions {
+			if _, err := time.Parse(dateLayout, d.ExDate); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid ex_date %q, expected YYYY-MM-DD", d.ExDate)})
+				return
+			}
+			if seen[d.ExDate] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "duplicate distribution for " + d.ExDate})
+				return
+			}
+			seen[d.ExDate] = true
+		}
+		sort.Slice(distributions, func(i, j int) bool { return distributions[i].ExDate < distributions[j].ExDate })
+
+		if err := replaceFundDistributions(c.GetString("user_id"), ticker, distributions); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, distributions)
+	})
+
+	protected.PUT("/:id/security-type", func(c *gin.Context) {
+		var req HoldingSecurityTypeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if db == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database unavailable - cannot update holdings"})
+			return
+		}
+
+		holding, err := scanHolding(db.QueryRow(`
+			UPDATE portfolio_holdings SET security_type = $1, updated_at = NOW()
+			WHERE id = $2 AND user_id = $3
+			RETURNING `+holdingColumns,
+			req.SecurityType, c.Param("id"), c.GetString("user_id"),
+		))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Holding not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to update holding: %v", err)})
+			return
+		}
+		c.JSON(http.StatusOK, holding)
+	})
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultBaseCurrency is used for users who have not chosen one, and for
+// holdings stored before their currency was recorded.
+const defaultBaseCurrency = "USD"
+
+// fxCacheTTL is how long a provider rate is reused.
+const fxCacheTTL = time.Hour
+
+// FXRate is the price of one unit of From in To.
+type FXRate struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Rate   float64   `json:"rate"`
+	AsOf   time.Time `json:"as_of"`
+	Source string    `json:"source"`
+}
+
+// FXRateSource provides exchange rates between ISO currency codes.
+// Subunit currencies and same-currency conversions never reach it.
+type FXRateSource interface {
+	Rate(from, to string) (FXRate, error)
+}
+
+// fxSource is the rate source used for conversions; main sets it from the
+// environment.
+var fxSource FXRateSource
+
+// currencySubunits are listing currencies quoted in hundredths of a main
+// currency, as FMP reports London, Johannesburg and Tel Aviv prices.
+var currencySubunits = map[string]string{"GBp": "GBP", "GBX": "GBP", "ZAc": "ZAR", "ILA": "ILS"}
+
+// normalizeCurrency returns the ISO code for a stored currency and the
+// factor converting amounts into it. Blank currencies are taken as USD.
+func normalizeCurrency(code string) (string, float64) {
+	code = strings.TrimSpace(code)
+	if main, ok := currencySubunits[code]; ok {
+		return main, 0.01
+	}
+	if code == "" {
+		return defaultBaseCurrency, 1
+	}
+	return strings.ToUpper(code), 1
+}
+
+// fmpFXSource quotes currency pairs through FMP, caching each pair for
+// fxCacheTTL.
+type fmpFXSource struct {
+	apiKey string
+	mu     sync.Mutex
+	cache  map[string]fmpFXEntry
+}
+
+type fmpFXEntry struct {
+	rate    FXRate
+	fetched time.Time
+}
+
+func newFMPFXSource(apiKey string) *fmpFXSource {
+	return &fmpFXSource{apiKey: apiKey, cache: map[string]fmpFXEntry{}}
+}
+
+func (s *fmpFXSource) Rate(from, to string) (FXRate, error) {
+	pair := from + to
+	s.mu.Lock()
+	entry, ok := s.cache[pair]
+	s.mu.Unlock()
+	if ok && time.Since(entry.fetched) < fxCacheTTL {
+		return entry.rate, nil
+	}
+
+	url := fmt.Sprintf("https://financialmodelingprep.com/api/v3/quote/%s?apikey=%s", pair, s.apiKey)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return FXRate{}, fmt.Errorf("failed to fetch %s/%s rate from FMP: %v", from, to, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return FXRate{}, fmt.Errorf("FMP FX API returned status %d", resp.StatusCode)
+	}
+
+	var fmpResp []struct {
+		Symbol    string  `json:"symbol"`
+		Price     float64 `json:"price"`
+		Timestamp int64   `json:"timestamp"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&fmpResp); err != nil {
+		return FXRate{}, fmt.Errorf("failed to parse FMP FX response: %v", err)
+	}
+	if len(fmpResp) == 0 || fmpResp[0].Price <= 0 {
+		return FXRate{}, fmt.Errorf("no FX rate found for %s/%s", from, to)
+	}
+
+	rate := FXRate{From: from, To: to, Rate: fmpResp[0].Price, AsOf: time.Unix(fmpResp[0].Timestamp, 0).UTC(), Source: "fmp"}
+	s.mu.Lock()
+	s.cache[pair] = fmpFXEntry{rate: rate, fetched: time.Now()}
+	s.mu.Unlock()
+	return rate, nil
+}
+
+// staticFXSource serves fixed rates from a file, for offline use and
+// testing. Inverse rates and crosses through USD are derived.
+type staticFXSource struct {
+	rates  map[string]float64
+	asOf   map[string]time.Time
+	source string
+}
+
+// parseStaticFX reads from,to,rate[,as_of] rows, e.g.
+// "EUR,USD,1.0812,2024-06-14". Rows without a date are as of asOf. A
+// header row is ignored.
+func parseStaticFX(r io.Reader, source string, asOf time.Time) (*staticFXSource, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	s := &staticFXSource{rates: map[string]float64{}, asOf: map[string]time.Time{}, source: source}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read FX rates line %d: %v", line, err)
+		}
+		if len(record) < 3 {
+			continue
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+		if err != nil || rate <= 0 {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("invalid FX rate on line %d: %q", line, record[2])
+		}
+		pair := strings.ToUpper(strings.TrimSpace(record[0])) + strings.ToUpper(strings.TrimSpace(record[1]))
+		s.rates[pair] = rate
+		s.asOf[pair] = asOf
+		if len(record) > 3 {
+			d, err := time.Parse(dateLayout, strings.TrimSpace(record[3]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid FX rate date on line %d: %q", line, record[3])
+			}
+			s.asOf[pair] = d
+		}
+	}
+	if len(s.rates) == 0 {
+		return nil, fmt.Errorf("no FX rates found in %s", source)
+	}
+	return s, nil
+}
+
+func (s *staticFXSource) lookup(from, to string) (float64, time.Time, bool) {
+	if r, ok := s.rates[from+to]; ok {
+		return r, s.asOf[from+to], true
+	}
+	if r, ok := s.rates[to+from]; ok {
+		return 1 / r, s.asOf[to+from], true
+	}
+	return 0, time.Time{}, false
+}
+
+func (s *staticFXSource) Rate(from, to string) (FXRate, error) {
+	if r, asOf, ok := s.lookup(from, to); ok {
+		return FXRate{From: from, To: to, Rate: r, AsOf: asOf, Source: s.source}, nil
+	}
+	r1, asOf1, ok1 := s.lookup(from, "USD")
+	r2, asOf2, ok2 := s.lookup("USD", to)
+	if !ok1 || !ok2 {
+		return FXRate{}, fmt.Errorf("no FX rate for %s/%s in %s", from, to, s.source)
+	}
+	// A cross rate is only as current as its older leg
+	if asOf2.Before(asOf1) {
+		asOf1 = asOf2
+	}
+	return FXRate{From: from, To: to, Rate: r1 * r2, AsOf: asOf1, Source: s.source}, nil
+}
+
+// newFXSourceFromEnv uses the static rates in FX_RATES_PATH when set, and
+// FMP otherwise.
+func newFXSourceFromEnv(apiKey string) (FXRateSource, error) {
+	path := os.Getenv("FX_RATES_PATH")
+	if path == "" {
+		return newFMPFXSource(apiKey), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open FX rates file: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read FX rates file: %v", err)
+	}
+	return parseStaticFX(f, path, info.ModTime().UTC())
+}
+
+// fxConversion converts amounts into one base currency, fetching each
+// rate once and remembering the rates used.
+type fxConversion struct {
+	base   string
+	source FXRateSource
+	rates  map[string]FXRate
+	used   []FXRate
+}
+
+func newFXConversion(base string, source FXRateSource) *fxConversion {
+	return &fxConversion{base: base, source: source, rates: map[string]FXRate{}, used: []FXRate{}}
+}
+
+func (c *fxConversion) convert(amount float64, currency string) (float64, error) {
+	code, scale := normalizeCurrency(currency)
+	if code == c.base {
+		return amount * scale, nil
+	}
+	r, ok := c.rates[code]
+	if !ok {
+		if c.source == nil {
+			return 0, fmt.Errorf("no FX rate source configured")
+		}
+		var err error
+		r, err = c.source.Rate(code, c.base)
+		if err != nil {
+			return 0, err
+		}
+		c.rates[code] = r
+		c.used = append(c.used, r)
+	}
+	return amount * scale * r.Rate, nil
+}
+
+// convertHoldings returns copies of holdings with prices and values in
+// the trading currency, and dividends in the dividend currency, converted
+// into the base currency.
+func convertHoldings(holdings []PortfolioHolding, conv *fxConversion) ([]PortfolioHolding, error) {
+	converted := make([]PortfolioHolding, 0, len(holdings))
+	for _, h := range holdings {
+		dividendCurrency := h.DividendCurrency
+		if dividendCurrency == "" {
+			dividendCurrency = h.Currency
+		}
+		price, err := conv.convert(h.CurrentPrice, h.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s: %v", h.Ticker, err)
+		}
+		value, err := conv.convert(h.TotalValue, h.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s: %v", h.Ticker, err)
+		}
+		monthly, err := conv.convert(h.MonthlyDividend, dividendCurrency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s dividends: %v", h.Ticker, err)
+		}
+
+		h.CurrentPrice, h.TotalValue, h.MonthlyDividend = roundTo(price, 4), roundTo(value, 2), roundTo(monthly, 2)
+		if h.TotalValue > 0 {
+			h.DividendYield = roundTo(h.MonthlyDividend*12/h.TotalValue*100, 2)
+		}
+		h.Currency, h.DividendCurrency = conv.base, conv.base
+		converted = append(converted, h)
+	}
+	return converted, nil
+}
+
+// ledgerFX converts transactions into the base currency. Transactions
+// carry no currency of their own: amounts are taken to be in the ticker's
+// trading currency, from the user's holdings or, for tickers no longer
+// held, the company profile. Like holdings they convert at current rates.
+type ledgerFX struct {
+	conv       *fxConversion
+	apiKey     string
+	currencies map[string]string
+}
+
+// newLedgerFX converts through conv, sharing its rates, or through a new
+// conversion into the user's base currency when conv is nil.
+func newLedgerFX(userID string, conv *fxConversion, apiKey string) (*ledgerFX, error) {
+	if conv == nil {
+		base, err := getBaseCurrency(userID)
+		if err != nil {
+			return nil, err
+		}
+		conv = newFXConversion(strings.ToUpper(base), fxSource)
+	}
+	holdings, err := getRawScopedHoldings(userID, "")
+	if err != nil {
+		return nil, err
+	}
+	currencies := map[string]string{}
+	for _, h := range holdings {
+		if currencies[h.Ticker] == "" {
+			currencies[h.Ticker] = h.Currency
+		}
+	}
+	return &ledgerFX{conv: conv, apiKey: apiKey, currencies: currencies}, nil
+}
+
+// rate returns the factor converting an amount in ticker's trading
+// currency into the base currency.
+func (f *ledgerFX) rate(ticker string) (float64, error) {
+	currency, ok := f.currencies[ticker]
+	if !ok {
+		if profile, err := fetchFMPProfile(ticker, f.apiKey); err == nil {
+			currency = profile.Currency
+		}
+		f.currencies[ticker] = currency
+	}
+	rate, err := f.conv.convert(1, currency)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert %s: %v", ticker, err)
+	}
+	return rate, nil
+}
+
+// convertTransactions returns copies of transactions with prices, fees and
+// amounts in the base currency.
+func (f *ledgerFX) convertTransactions(transactions []Transaction) ([]Transaction, error) {
+	converted := make([]Transaction, 0, len(transactions))
+	for _, t := range transactions {
+		rate, err := f.rate(t.Ticker)
+		if err != nil {
+			return nil, err
+		}
+		t.Price, t.Fees, t.Amount = t.Price*rate, t.Fees*rate, t.Amount*rate
+		converted = append(converted, t)
+	}
+	return converted, nil
+}
+
+func getBaseCurrency(userID string) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("database unavailable - cannot load base currency")
+	}
+
+	var base string
+	err := db.QueryRow("SELECT base_currency FROM currency_settings WHERE user_id = $1", userID).Scan(&base)
+	if err == sql.ErrNoRows {
+		return defaultBaseCurrency, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load base currency: %v", err)
+	}
+	return base, nil
+}
+
+func saveBaseCurrency(userID, base string) error {
+	if db == nil {
+		return fmt.Errorf("database unavailable - cannot save base currency")
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO currency_settings (user_id, base_currency, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET base_currency = EXCLUDED.base_currency, updated_at = NOW()
+	`, userID, base)
+	if err != nil {
+		return fmt.Errorf("failed to save base currency: %v", err)
+	}
+	return nil
+}
+
+// getBaseHoldings returns the scoped holdings converted into the
+// requested base currency, or the user's own when base is empty, with
+// the rates used.
+func getBaseHoldings(userID, portfolioID, base string) ([]PortfolioHolding, *fxConversion, error) {
+	holdings, err := getRawScopedHoldings(userID, portfolioID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if base == "" {
+		if base, err = getBaseCurrency(userID); err != nil {
+			return nil, nil, err
+		}
+	}
+	conv := newFXConversion(strings.ToUpper(base), fxSource)
+	converted, err := convertHoldings(holdings, conv)
+	if err != nil {
+		return nil, nil, err
+	}
+	return converted, conv, nil
+}
+
+// CurrencySettings reports the base currency and the rate from each
+// currency the user holds.
+type CurrencySettings struct {
+	BaseCurrency string   `json:"base_currency"`
+	Rates        []FXRate `json:"rates"`
+}
+
+type CurrencySettingsRequest struct {
+	BaseCurrency string `json:"base_currency" binding:"required,len=3"`
+}
+
+type HoldingCurrencyRequest struct {
+	DividendCurrency string `json:"dividend_currency" binding:"required,len=3"`
+}
+
+func registerCurrencyRoutes(protected *gin.RouterGroup) {
+	protected.GET("/currency", func(c *gin.Context) {
+		_, conv, err := getBaseHoldings(c.GetString("user_id"), "", c.Query("base_currency"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, CurrencySettings{BaseCurrency: conv.base, Rates: conv.used})
+	})
+
+	protected.PUT("/currency", func(c *gin.Context) {
+		var req CurrencySettingsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		base := strings.ToUpper(req.BaseCurrency)
+		if base != defaultBaseCurrency {
+			// Only accept currencies the rate source can quote
+			if _, err := newFXConversion(base, fxSource).convert(1, defaultBaseCurrency); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported base currency %s: %v", base, err)})
+				return
+			}
+		}
+
+		userID := c.GetString("user_id")
+		if err := saveBaseCurrency(userID, base); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		_, conv, err := getBaseHoldings(userID, "", base)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, CurrencySettings{BaseCurrency: conv.base, Rates: conv.used})
+	})
+
+	protected.PUT("/:id/currency", func(c *gin.Context) {
+		var req HoldingCurrencyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if db == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database unavailable - cannot update holdings"})
+			return
+		}
+
+		// Subunit codes such as GBp are case-sensitive; anything else is
+		// stored upper-case, and only if the rate source can quote it
+		currency := strings.TrimSpace(req.DividendCurrency)
+		if _, ok := currencySubunits[currency]; !ok {
+			currency = strings.ToUpper(currency)
+		}
+		if code, _ := normalizeCurrency(currency); code != defaultBaseCurrency {
+			if _, err := newFXConversion(defaultBaseCurrency, fxSource).convert(1, currency); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported dividend currency %s: %v", currency, err)})
+				return
+			}
+		}
+		holding, err := scanHolding(db.QueryRow(`
+			UPDATE portfolio_holdings SET dividend_currency = $1, updated_at = NOW()
+			WHERE id = $2 AND user_id = $3
+			RETURNING `+holdingColumns,
+			currency, c.Param("id"), c.GetString("user_id"),
+		))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Holding not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to update holding: %v", err)})
+			return
+		}
+		c.JSON(http.StatusOK, holding)
+	})
+}
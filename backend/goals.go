@@ -0,0 +1,339 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errGoalNotFound = errors.New("income goal not found")
+
+// goalHorizonYears is how far ahead goals are projected when estimating
+// when they will be reached.
+const goalHorizonYears = 50
+
+// IncomeGoal is a monthly dividend income target, optionally by a date,
+// with the projection assumptions used to estimate when it is reached.
+// Growth rates follow ProjectionRequest: a null DividendGrowth uses each
+// holding's historical CAGR.
+type IncomeGoal struct {
+	ID                  string    `json:"id"`
+	Name                string    `json:"name"`
+	TargetMonthlyIncome float64   `json:"target_monthly_income"`
+	TargetDate          *string   `json:"target_date"`
+	MonthlyContribution float64   `json:"monthly_contribution"`
+	Drip                bool      `json:"drip"`
+	DividendGrowth      *float64  `json:"dividend_growth"`
+	PriceGrowth         float64   `json:"price_growth"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// IncomeGoalRequest creates or replaces a goal. Drip defaults to true.
+type IncomeGoalRequest struct {
+	Name                string   `json:"name" binding:"required"`
+	TargetMonthlyIncome float64  `json:"target_monthly_income" binding:"required,gt=0"`
+	TargetDate          *string  `json:"target_date"`
+	MonthlyContribution float64  `json:"monthly_contribution" binding:"min=0"`
+	Drip                *bool    `json:"drip"`
+	DividendGrowth      *float64 `json:"dividend_growth" binding:"omitempty,min=-100"`
+	PriceGrowth         float64  `json:"price_growth" binding:"min=-100"`
+}
+
+// GoalProgress reports a goal against the current holdings. Goals are
+// met by income net of withholding: CurrentMonthlyIncome is net, and
+// CurrentMonthly splits it into gross, withheld and net.
+// AdditionalCapital is what would have to be invested at the current
+// portfolio yield, net of withholding, to close the gap today.
+// EstimatedDate is null when the projection does not reach the goal within
+// goalHorizonYears; OnTrack is null for goals without a target date.
+type GoalProgress struct {
+	IncomeGoal
+	CurrentMonthlyIncome float64         `json:"current_monthly_income"`
+	CurrentMonthly       DividendAmounts `json:"current_monthly"`
+	ProgressPercent      float64         `json:"progress_percent"`
+	PortfolioYield       float64         `json:"portfolio_yield"`
+	AdditionalCapital    float64         `json:"additional_capital"`
+	Achieved             bool            `json:"achieved"`
+	EstimatedDate        *string         `json:"estimated_date"`
+	OnTrack              *bool           `json:"on_track"`
+}
+
+func (req *IncomeGoalRequest) normalize() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.TargetDate != nil {
+		if _, err := time.Parse(dateLayout, *req.TargetDate); err != nil {
+			return fmt.Errorf("target_date must be a date in YYYY-MM-DD format")
+		}
+	}
+	if req.Drip == nil {
+		drip := true
+		req.Drip = &drip
+	}
+	return nil
+}
+
+const goalColumns = `id, name, target_monthly_income, target_date, monthly_contribution, drip, dividend_growth, price_growth, created_at, updated_at`
+
+func scanGoal(row rowScanner) (IncomeGoal, error) {
+	var g IncomeGoal
+	var targetDate sql.NullTime
+	var dividendGrowth sql.NullFloat64
+	err := row.Scan(&g.ID, &g.Name, &g.TargetMonthlyIncome, &targetDate, &g.MonthlyContribution, &g.Drip, &dividendGrowth, &g.PriceGrowth, &g.CreatedAt, &g.UpdatedAt)
+	if targetDate.Valid {
+		d := targetDate.Time.Format(dateLayout)
+		g.TargetDate = &d
+	}
+	if dividendGrowth.Valid {
+		g.DividendGrowth = &dividendGrowth.Float64
+	}
+	return g, err
+}
+
+func getGoals(userID string) ([]IncomeGoal, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot load income goals")
+	}
+
+	rows, err := db.Query(`SELECT `+goalColumns+` FROM income_goals WHERE user_id = $1 ORDER BY target_monthly_income, created_at`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query income goals: %v", err)
+	}
+	defer rows.Close()
+
+	goals := []IncomeGoal{}
+	for rows.Next() {
+		g, err := scanGoal(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan income goal: %v", err)
+		}
+		goals = append(goals, g)
+	}
+	return goals, rows.Err()
+}
+
+func createGoal(req IncomeGoalRequest, userID string) (*IncomeGoal, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot create income goals")
+	}
+
+	g, err := scanGoal(db.QueryRow(`
+		INSERT INTO income_goals (user_id, name, target_monthly_income, target_date, monthly_contribution, drip, dividend_growth, price_growth, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW())
+		RETURNING `+goalColumns,
+		userID, req.Name, req.TargetMonthlyIncome, req.TargetDate, req.MonthlyContribution, *req.Drip, req.DividendGrowth, req.PriceGrowth,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert income goal: %v", err)
+	}
+	return &g, nil
+}
+
+func updateGoal(id string, req IncomeGoalRequest, userID string) (*IncomeGoal, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot update income goals")
+	}
+
+	g, err := scanGoal(db.QueryRow(`
+		UPDATE income_goals
+		SET name = $1, target_monthly_income = $2, target_date = $3, monthly_contribution = $4, drip = $5,
+			dividend_growth = $6, price_growth = $7, updated_at = NOW()
+		WHERE id = $8 AND user_id = $9
+		RETURNING `+goalColumns,
+		req.Name, req.TargetMonthlyIncome, req.TargetDate, req.MonthlyContribution, *req.Drip, req.DividendGrowth, req.PriceGrowth, id, userID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, errGoalNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update income goal: %v", err)
+	}
+	return &g, nil
+}
+
+func deleteGoal(id string, userID string) error {
+	if db == nil {
+		return fmt.Errorf("database unavailable - cannot delete income goals")
+	}
+
+	result, err := db.Exec("DELETE FROM income_goals WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete income goal: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return errGoalNotFound
+	}
+
+	return nil
+}
+
+// goalProgress projects holdings under g's assumptions. The achievement
+// date is interpolated within the year the target is first reached.
+func goalProgress(g IncomeGoal, holdings []projectionHolding, now time.Time) GoalProgress {
+	p := GoalProgress{IncomeGoal: g}
+
+	var value, gross, withheld float64
+	for _, h := range holdings {
+		value += h.Shares * h.Price
+		gross += h.Shares * h.AnnualDividend
+		withheld += h.Shares * h.AnnualDividend * h.WithholdingRate / 100
+	}
+	annual := gross - withheld
+	p.CurrentMonthly = DividendAmounts{Gross: gross / 12, Withheld: withheld / 12, Net: annual / 12}
+	p.CurrentMonthly.round()
+	p.CurrentMonthlyIncome = p.CurrentMonthly.Net
+	p.ProgressPercent = roundTo(annual/12/g.TargetMonthlyIncome*100, 2)
+	if value > 0 {
+		p.PortfolioYield = roundTo(gross/value*100, 2)
+	}
+	gap := g.TargetMonthlyIncome*12 - annual
+	if gap <= 0 {
+		p.Achieved = true
+	} else if annual > 0 && value > 0 {
+		p.AdditionalCapital = roundTo(gap/(annual/value), 2)
+	}
+
+	req := ProjectionRequest{
+		Years:               goalHorizonYears,
+		MonthlyContribution: g.MonthlyContribution,
+		Drip:                g.Drip,
+	}
+	series := projectPath(holdings, req, func(y, i int) (float64, float64) {
+		if g.DividendGrowth != nil {
+			return g.PriceGrowth, *g.DividendGrowth
+		}
+		return g.PriceGrowth, holdings[i].DividendGrowth
+	})
+
+	target := g.TargetMonthlyIncome * 12
+	for y, point := range series {
+		if point.NetAnnualIncome < target {
+			continue
+		}
+		months := 0
+		if y > 0 {
+			prev := series[y-1].NetAnnualIncome
+			frac := (target - prev) / (point.NetAnnualIncome - prev)
+			months = (y-1)*12 + int(math.Ceil(frac*12))
+		}
+		date := now.AddDate(0, months, 0).Format(dateLayout)
+		p.EstimatedDate = &date
+		break
+	}
+
+	if g.TargetDate != nil {
+		onTrack := p.EstimatedDate != nil && *p.EstimatedDate <= *g.TargetDate
+		p.OnTrack = &onTrack
+	}
+	return p
+}
+
+// getGoalsProgress reports every goal of the user against all of their
+// holdings.
+func getGoalsProgress(userID, apiKey string) ([]GoalProgress, error) {
+	goals, err := getGoals(userID)
+	if err != nil {
+		return nil, err
+	}
+	progress := []GoalProgress{}
+	if len(goals) == 0 {
+		return progress, nil
+	}
+
+	// Historical growth is only looked up when a goal relies on it
+	var fixed float64
+	req := ProjectionRequest{DividendGrowth: &fixed}
+	for _, g := range goals {
+		if g.DividendGrowth == nil {
+			req.DividendGrowth = nil
+			break
+		}
+	}
+	holdings, _, _, err := loadProjectionHoldings(userID, req, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, g := range goals {
+		progress = append(progress, goalProgress(g, holdings, now))
+	}
+	return progress, nil
+}
+
+// goalErrorStatus maps goal errors onto HTTP status codes.
+func goalErrorStatus(err error) int {
+	if err == errGoalNotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func registerGoalRoutes(protected *gin.RouterGroup, apiKey string) {
+	protected.GET("/goals", func(c *gin.Context) {
+		progress, err := getGoalsProgress(c.GetString("user_id"), apiKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, progress)
+	})
+
+	protected.POST("/goals", func(c *gin.Context) {
+		var req IncomeGoalRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := req.normalize(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		goal, err := createGoal(req, c.GetString("user_id"))
+		if err != nil {
+			c.JSON(goalErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, goal)
+	})
+
+	protected.PUT("/goals/:id", func(c *gin.Context) {
+		var req IncomeGoalRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := req.normalize(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		goal, err := updateGoal(c.Param("id"), req, c.GetString("user_id"))
+		if err != nil {
+			c.JSON(goalErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, goal)
+	})
+
+	protected.DELETE("/goals/:id", func(c *gin.Context) {
+		if err := deleteGoal(c.Param("id"), c.GetString("user_id")); err != nil {
+			c.JSON(goalErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Income goal deleted successfully"})
+	})
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// What an import file describes.
+const (
+	importHoldings     = "holdings"
+	importTransactions = "transactions"
+)
+
+// maxImportBytes caps the size of an uploaded import file.
+const maxImportBytes = 5 << 20
+
+type importedHolding struct {
+	Line   int
+	Ticker string
+	Shares float64
+}
+
+type importedTransaction struct {
+	Line    int
+	Request TransactionRequest
+}
+
+// importRecords is what a file parser hands to the import pipeline, before
+// it is compared with what the user already has.
+type importRecords struct {
+	holdings     []importedHolding
+	transactions []importedTransaction
+	skipped      []ImportRow
+	conflicts    []ImportRow
+}
+
+func (r *importRecords) skip(line int, ticker, message string) {
+	r.skipped = append(r.skipped, ImportRow{Lines: []int{line}, Ticker: ticker, Message: message})
+}
+
+func (r *importRecords) conflict(line int, ticker, message string) {
+	r.conflicts = append(r.conflicts, ImportRow{Lines: []int{line}, Ticker: ticker, Message: message})
+}
+
+// ImportRow is one planned change. Lines are the file lines it came from;
+// a holding listed several times (e.g. in two accounts) is combined.
+type ImportRow struct {
+	Lines         []int               `json:"lines"`
+	Ticker        string              `json:"ticker"`
+	Shares        int                 `json:"shares,omitempty"`
+	CurrentShares int                 `json:"current_shares,omitempty"`
+	Transaction   *TransactionRequest `json:"transaction,omitempty"`
+	Message       string              `json:"message,omitempty"`
+
+	holdingID string
+}
+
+// ImportResult previews, or reports, an import. Unchanged holds holdings
+// already at the imported share count and transactions already in the
+// ledger; neither is written again. Conflicts block the commit.
+type ImportResult struct {
+	Format      string      `json:"format"`
+	Broker      string      `json:"broker,omitempty"`
+	Type        string      `json:"type"`
+	PortfolioID string      `json:"portfolio_id"`
+	DryRun      bool        `json:"dry_run"`
+	Committed   bool        `json:"committed"`
+	Adds        []ImportRow `json:"adds"`
+	Updates     []ImportRow `json:"updates"`
+	Unchanged   []ImportRow `json:"unchanged"`
+	Conflicts   []ImportRow `json:"conflicts"`
+	Skipped     []ImportRow `json:"skipped"`
+}
+
+// transactionKey identifies a ledger entry for duplicate detection.
+func transactionKey(ticker, txType, date string, shares, amount float64) string {
+	return fmt.Sprintf("%s|%s|%s|%.6f|%.2f", ticker, txType, date, shares, amount)
+}
+
+// planImport compares parsed records with the portfolio. Holdings set the
+// share count of a position; transactions are appended to the ledger.
+func planImport(records *importRecords, kind, userID, portfolioID string) (*ImportResult, error) {
+	result := &ImportResult{
+		Type:        kind,
+		PortfolioID: portfolioID,
+		Adds:        []ImportRow{},
+		Updates:     []ImportRow{},
+		Unchanged:   []ImportRow{},
+		Conflicts:   append([]ImportRow{}, records.conflicts...),
+		Skipped:     append([]ImportRow{}, records.skipped...),
+	}
+
+	if kind == importHoldings {
+		existing, err := getPortfolioHoldings(portfolioID, userID)
+		if err != nil {
+			return nil, err
+		}
+		held := map[string]PortfolioHolding{}
+		for _, h := range existing {
+			held[h.Ticker] = h
+		}
+
+		combined := map[string]*ImportRow{}
+		totals := map[string]float64{}
+		var tickers []string
+		for _, h := range records.holdings {
+			row, ok := combined[h.Ticker]
+			if !ok {
+				row = &ImportRow{Ticker: h.Ticker}
+				combined[h.Ticker] = row
+				tickers = append(tickers, h.Ticker)
+			}
+			row.Lines = append(row.Lines, h.Line)
+			totals[h.Ticker] += h.Shares
+		}
+		sort.Strings(tickers)
+
+		for _, ticker := range tickers {
+			row, total := combined[ticker], totals[ticker]
+			row.Shares = int(math.Round(total))
+			if row.Shares <= 0 {
+				row.Message = fmt.Sprintf("quantity %g is not a positive share count", total)
+				result.Conflicts = append(result.Conflicts, *row)
+				continue
+			}
+			if float64(row.Shares) != total {
+				row.Message = fmt.Sprintf("rounded from %g shares", total)
+			}
+
+			h, ok := held[ticker]
+			switch {
+			case !ok:
+				result.Adds = append(result.Adds, *row)
+			case h.Shares == row.Shares:
+				row.CurrentShares = h.Shares
+				result.Unchanged = append(result.Unchanged, *row)
+			default:
+				row.CurrentShares = h.Shares
+				row.holdingID = h.ID
+				result.Updates = append(result.Updates, *row)
+			}
+		}
+		return result, nil
+	}
+
+	existing, err := getTransactions(userID, TransactionFilter{PortfolioID: portfolioID})
+	if err != nil {
+		return nil, err
+	}
+	recorded := map[string]bool{}
+	for _, t := range existing {
+		recorded[transactionKey(t.Ticker, t.Type, t.TradeDate, t.Shares, t.Amount)] = true
+	}
+
+	for _, t := range records.transactions {
+		req := t.Request
+		req.PortfolioID = portfolioID
+		row := ImportRow{Lines: []int{t.Line}, Ticker: req.Ticker, Transaction: &req}
+		if err := req.normalize(); err != nil {
+			row.Message = err.Error()
+			result.Conflicts = append(result.Conflicts, row)
+			continue
+		}
+		if recorded[transactionKey(req.Ticker, req.Type, req.TradeDate, req.Shares, req.Amount)] {
+			row.Message = "already in the transaction ledger"
+			result.Unchanged = append(result.Unchanged, row)
+			continue
+		}
+		result.Adds = append(result.Adds, row)
+	}
+	return result, nil
+}
+
+// commitImport writes a planned import in one database transaction, so a
+// failure leaves nothing behind. Market data for new holdings is fetched
+// before the transaction starts.
+func commitImport(result *ImportResult, userID, apiKey string) error {
+	if db == nil {
+		return fmt.Errorf("database unavailable - cannot import")
+	}
+
+	var summaries []*DividendSummary
+	if result.Type == importHoldings {
+		for _, row := range result.Adds {
+			summary, err := getDividendSummary(row.Ticker, apiKey, row.Shares)
+			if err != nil {
+				return fmt.Errorf("failed to get stock data for %s: %v", row.Ticker, err)
+			}
+			summaries = append(summaries, summary)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin import transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, summary := range summaries {
+		if _, err := insertHolding(tx, summary, userID, result.PortfolioID); err != nil {
+			return fmt.Errorf("failed to import %s: %v", summary.Ticker, err)
+		}
+	}
+
+	if result.Type == importHoldings {
+		// Keep the stored price and per-share dividend, scaled to the new count
+		for _, row := range result.Updates {
+			_, err := tx.Exec(`
+				UPDATE portfolio_holdings
+				SET total_value = current_price * $1,
+					monthly_dividend = CASE WHEN shares > 0 THEN monthly_dividend * $1 / shares ELSE monthly_dividend END,
+					shares = $1, updated_at = NOW()
+				WHERE id = $2 AND user_id = $3
+			`, row.Shares, row.holdingID, userID)
+			if err != nil {
+				return fmt.Errorf("failed to update %s: %v", row.Ticker, err)
+			}
+		}
+	} else {
+		for _, row := range result.Adds {
+			t := row.Transaction
+			_, err := tx.Exec(`
+				INSERT INTO transactions (portfolio_id, ticker, type, trade_date, shares, price, fees, amount, notes, user_id, created_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW())
+			`, result.PortfolioID, t.Ticker, t.Type, t.TradeDate, t.Shares, t.Price, t.Fees, t.Amount, t.Notes, userID)
+			if err != nil {
+				return fmt.Errorf("failed to import %s transaction on line %d: %v", t.Ticker, row.Lines[0], err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit import: %v", err)
+	}
+	result.Committed = true
+	return nil
+}
+
+// importParam reads an import option from the query string or, for
+// multipart uploads, the form.
+func importParam(c *gin.Context, name, fallback string) string {
+	if v := c.Query(name); v != "" {
+		return v
+	}
+	if v := c.PostForm(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func registerImportRoutes(protected *gin.RouterGroup, apiKey string) {
+	protected.POST("/import", func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+		userID := c.GetString("user_id")
+		format := strings.ToLower(importParam(c, "format", "csv"))
+		broker := strings.ToLower(importParam(c, "broker", "generic"))
+		kind := strings.ToLower(importParam(c, "type", importHoldings))
+		dryRun := importParam(c, "dry_run", "true") != "false"
+
+		if format == "qfx" {
+			format = "ofx"
+		}
+		if format != "csv" && format != "ofx" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv, ofx or qfx"})
+			return
+		}
+		if kind != importHoldings && kind != importTransactions {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type must be holdings or transactions"})
+			return
+		}
+		if _, ok := importMappings[broker]; !ok && format == "csv" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "broker must be one of schwab, fidelity, vanguard or generic"})
+			return
+		}
+
+		var body io.Reader = c.Request.Body
+		if strings.HasPrefix(c.ContentType(), "multipart/") {
+			header, err := c.FormFile("file")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "upload the export as a file field named file"})
+				return
+			}
+			f, err := header.Open()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			defer f.Close()
+			body = f
+		}
+
+		var records *importRecords
+		var err error
+		if format == "ofx" {
+			broker = ""
+			records, err = parseImportOFX(body, kind, func(cusip string) (string, error) {
+				return fetchFMPCusip(cusip, apiKey)
+			})
+		} else {
+			records, err = parseImportCSV(body, broker, kind)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		portfolioID, err := resolvePortfolioID(importParam(c, "portfolio_id", ""), userID)
+		if err != nil {
+			c.JSON(transactionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := planImport(records, kind, userID, portfolioID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		result.Format, result.Broker, result.DryRun = format, broker, dryRun
+
+		if dryRun {
+			c.JSON(http.StatusOK, result)
+			return
+		}
+		if len(result.Conflicts) > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "resolve the conflicts before importing; nothing was imported", "import": result})
+			return
+		}
+		if err := commitImport(result, userID, apiKey); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error() + "; nothing was imported"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+}
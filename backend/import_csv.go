@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// importColumns lists the accepted header names, lower-cased, for each
+// field of a broker export.
+type importColumns struct {
+	symbol   []string
+	quantity []string
+	date     []string
+	action   []string
+	price    []string
+	amount   []string
+	fees     []string
+}
+
+// actionRule maps broker action text containing match (upper-cased) to a
+// transaction type. Rules are tried in order.
+type actionRule struct {
+	match  string
+	txType string
+}
+
+// importMapping describes one broker's CSV exports. Adding a broker is a
+// matter of adding an entry to importMappings.
+type importMapping struct {
+	holdings     importColumns
+	transactions importColumns
+	actions      []actionRule
+}
+
+var importMappings = map[string]importMapping{
+	"generic": {
+		holdings: importColumns{
+			symbol:   []string{"ticker", "symbol"},
+			quantity: []string{"shares", "quantity"},
+		},
+		transactions: importColumns{
+			symbol:   []string{"ticker", "symbol"},
+			quantity: []string{"shares", "quantity"},
+			date:     []string{"date", "trade_date"},
+			action:   []string{"type", "action"},
+			price:    []string{"price"},
+			amount:   []string{"amount"},
+			fees:     []string{"fees"},
+		},
+		actions: []actionRule{
+			{"DIVIDEND", TransactionDividend},
+			{"BUY", TransactionBuy},
+			{"SELL", TransactionSell},
+		},
+	},
+	"schwab": {
+		holdings: importColumns{
+			symbol:   []string{"symbol"},
+			quantity: []string{"quantity", "qty (quantity)"},
+		},
+		transactions: importColumns{
+			symbol:   []string{"symbol"},
+			quantity: []string{"quantity"},
+			date:     []string{"date"},
+			action:   []string{"action"},
+			price:    []string{"price"},
+			amount:   []string{"amount"},
+			fees:     []string{"fees & comm"},
+		},
+		actions: []actionRule{
+			{"REINVEST SHARES", TransactionBuy},
+			{"DIV", TransactionDividend},
+			{"BUY", TransactionBuy},
+			{"SELL", TransactionSell},
+		},
+	},
+	"fidelity": {
+		holdings: importColumns{
+			symbol:   []string{"symbol"},
+			quantity: []string{"quantity"},
+		},
+		transactions: importColumns{
+			symbol:   []string{"symbol"},
+			quantity: []string{"quantity"},
+			date:     []string{"run date"},
+			action:   []string{"action"},
+			price:    []string{"price ($)"},
+			amount:   []string{"amount ($)"},
+			fees:     []string{"fees ($)", "commission ($)"},
+		},
+		actions: []actionRule{
+			{"REINVESTMENT", TransactionBuy},
+			{"DIVIDEND RECEIVED", TransactionDividend},
+			{"YOU BOUGHT", TransactionBuy},
+			{"YOU SOLD", TransactionSell},
+		},
+	},
+	"vanguard": {
+		holdings: importColumns{
+			symbol:   []string{"symbol"},
+			quantity: []string{"shares"},
+		},
+		transactions: importColumns{
+			symbol:   []string{"symbol"},
+			quantity: []string{"shares"},
+			date:     []string{"trade date"},
+			action:   []string{"transaction type"},
+			price:    []string{"share price"},
+			amount:   []string{"net amount", "principal amount"},
+			fees:     []string{"commissions and fees"},
+		},
+		actions: []actionRule{
+			{"REINVESTMENT", TransactionBuy},
+			{"DIVIDEND", TransactionDividend},
+			{"BUY", TransactionBuy},
+			{"SELL", TransactionSell},
+		},
+	},
+}
+
+// importDateLayouts are the date formats seen in broker exports.
+var importDateLayouts = []string{dateLayout, "01/02/2006", "1/2/2006", "01/02/06", "1/2/06", "01-02-2006"}
+
+// parseImportNumber reads amounts like "$1,234.56", "-12.5" or "(7.00)".
+// Blank and "--" cells read as zero.
+func parseImportNumber(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "--" || s == "-" {
+		return 0, nil
+	}
+	negative := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	s = strings.Trim(s, "()")
+	s = strings.NewReplacer("$", "", ",", "", " ", "").Replace(s)
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	if negative {
+		v = -v
+	}
+	return v, nil
+}
+
+// parseImportDate accepts the formats in importDateLayouts. Schwab's
+// "06/14/2024 as of 06/13/2024" uses the first date.
+func parseImportDate(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, " "); i > 0 {
+		s = s[:i]
+	}
+	for _, layout := range importDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format(dateLayout), nil
+		}
+	}
+	return "", fmt.Errorf("invalid date %q", s)
+}
+
+// importSymbol cleans a symbol cell. Rows that are not securities, such
+// as cash, totals, pending activity and Fidelity's core money market
+// (marked "**"), return "".
+func importSymbol(s string) string {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if strings.HasSuffix(s, "**") || strings.ContainsAny(s, " &") {
+		return ""
+	}
+	return strings.TrimRight(s, "*")
+}
+
+// findColumns locates the header row: the first row containing a column
+// for every required field. Exports often start with account details
+// before the header.
+func findColumns(records [][]string, cols importColumns, required []string) (int, map[string]int, bool) {
+	fields := map[string][]string{
+		"symbol": cols.symbol, "quantity": cols.quantity, "date": cols.date,
+		"action": cols.action, "price": cols.price, "amount": cols.amount, "fees": cols.fees,
+	}
+
+	for i, record := range records {
+		index := map[string]int{}
+		for j, cell := range record {
+			name := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(cell, "\ufeff")))
+			for field, aliases := range fields {
+				if _, seen := index[field]; seen {
+					continue
+				}
+				for _, alias := range aliases {
+					if name == alias {
+						index[field] = j
+					}
+				}
+			}
+		}
+		found := true
+		for _, field := range required {
+			if _, ok := index[field]; !ok {
+				found = false
+				break
+			}
+		}
+		if found {
+			return i, index, true
+		}
+	}
+	return 0, nil, false
+}
+
+// parseImportCSV reads a broker export into import records, keeping the
+// file line number of each row.
+func parseImportCSV(r io.Reader, broker, kind string) (*importRecords, error) {
+	mapping, ok := importMappings[broker]
+	if !ok {
+		return nil, fmt.Errorf("unknown broker %q", broker)
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	var records [][]string
+	var lines []int
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+		records = append(records, record)
+		lines = append(lines, line)
+	}
+
+	cols, required := mapping.holdings, []string{"symbol", "quantity"}
+	if kind == importTransactions {
+		cols, required = mapping.transactions, []string{"symbol", "date", "action"}
+	}
+	header, index, ok := findColumns(records, cols, required)
+	if !ok {
+		return nil, fmt.Errorf("no %s header row found for %s %s export", strings.Join(required, "/"), broker, kind)
+	}
+
+	cell := func(record []string, field string) string {
+		if j, ok := index[field]; ok && j < len(record) {
+			return record[j]
+		}
+		return ""
+	}
+
+	result := &importRecords{}
+	for i := header + 1; i < len(records); i++ {
+		record, line := records[i], lines[i]
+		// A row with a different column count ends the section, e.g.
+		// Vanguard's holdings list before its transactions; single-cell
+		// rows are notes and disclaimers
+		if len(record) != len(records[header]) {
+			if len(record) > 1 {
+				break
+			}
+			continue
+		}
+
+		// Exports with several accounts repeat the header
+		if _, _, again := findColumns(records[i:i+1], cols, required); again {
+			continue
+		}
+
+		symbol := importSymbol(cell(record, "symbol"))
+		if symbol == "" {
+			result.skip(line, strings.TrimSpace(cell(record, "symbol")), "not a security")
+			continue
+		}
+		symbol, err := normalizeSymbol(symbol)
+		if err != nil {
+			result.conflict(line, strings.TrimSpace(cell(record, "symbol")), err.Error())
+			continue
+		}
+
+		quantity, err := parseImportNumber(cell(record, "quantity"))
+		if err != nil {
+			result.conflict(line, symbol, err.Error())
+			continue
+		}
+
+		if kind == importHoldings {
+			result.holdings = append(result.holdings, importedHolding{Line: line, Ticker: symbol, Shares: quantity})
+			continue
+		}
+
+		action := strings.ToUpper(cell(record, "action"))
+		txType := ""
+		for _, rule := range mapping.actions {
+			if strings.Contains(action, rule.match) {
+				txType = rule.txType
+				break
+			}
+		}
+		if txType == "" {
+			result.skip(line, symbol, fmt.Sprintf("unsupported action %q", strings.TrimSpace(cell(record, "action"))))
+			continue
+		}
+
+		date, err := parseImportDate(cell(record, "date"))
+		if err != nil {
+			result.conflict(line, symbol, err.Error())
+			continue
+		}
+
+		req := TransactionRequest{Ticker: symbol, Type: txType, TradeDate: date, Shares: abs(quantity)}
+		req.Price, err = parseImportNumber(cell(record, "price"))
+		if err == nil {
+			req.Amount, err = parseImportNumber(cell(record, "amount"))
+		}
+		if err == nil {
+			req.Fees, err = parseImportNumber(cell(record, "fees"))
+		}
+		if err != nil {
+			result.conflict(line, symbol, err.Error())
+			continue
+		}
+		req.Price, req.Amount, req.Fees = abs(req.Price), abs(req.Amount), abs(req.Fees)
+		if txType == TransactionDividend {
+			req.Shares, req.Price = 0, 0
+		}
+		result.transactions = append(result.transactions, importedTransaction{Line: line, Request: req})
+	}
+
+	return result, nil
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ofxNode is an OFX element. OFX 1.x is SGML, where elements holding a
+// value have no end tag; OFX 2.x is XML. Both read into the same tree.
+type ofxNode struct {
+	name     string
+	value    string
+	line     int
+	children []*ofxNode
+}
+
+// child returns the first direct child named name, or nil.
+func (n *ofxNode) child(name string) *ofxNode {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// text returns the value at a path of child names, or "".
+func (n *ofxNode) text(path ...string) string {
+	for _, name := range path {
+		n = n.child(name)
+	}
+	if n == nil {
+		return ""
+	}
+	return n.value
+}
+
+// all returns every descendant named name, in document order.
+func (n *ofxNode) all(name string) []*ofxNode {
+	var found []*ofxNode
+	for _, c := range n.children {
+		if c.name == name {
+			found = append(found, c)
+		}
+		found = append(found, c.all(name)...)
+	}
+	return found
+}
+
+// parseOFX reads an OFX or QFX file into an element tree rooted at OFX.
+// The SGML or XML header before the OFX element is ignored.
+func parseOFX(r io.Reader) (*ofxNode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OFX: %v", err)
+	}
+	doc := string(data)
+	start := strings.Index(strings.ToUpper(doc), "<OFX>")
+	if start < 0 {
+		return nil, fmt.Errorf("no <OFX> element found")
+	}
+	line := 1 + strings.Count(doc[:start], "\n")
+	doc = doc[start:]
+
+	root := &ofxNode{}
+	stack := []*ofxNode{root}
+	for i := 0; i < len(doc); {
+		if doc[i] != '<' {
+			end := strings.IndexByte(doc[i:], '<')
+			if end < 0 {
+				end = len(doc) - i
+			}
+			text := doc[i : i+end]
+			line += strings.Count(text, "\n")
+			// Text right after a start tag is that element's value; in
+			// SGML it also ends the element
+			top := stack[len(stack)-1]
+			if value := strings.TrimSpace(text); value != "" && len(stack) > 1 && len(top.children) == 0 {
+				top.value = html.UnescapeString(value)
+				stack = stack[:len(stack)-1]
+			}
+			i += end
+			continue
+		}
+
+		end := strings.IndexByte(doc[i:], '>')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated tag on line %d", line)
+		}
+		tag := strings.ToUpper(strings.TrimSpace(doc[i+1 : i+end]))
+		line += strings.Count(doc[i:i+end], "\n")
+		i += end + 1
+
+		switch {
+		case tag == "" || tag[0] == '?' || tag[0] == '!':
+		case tag[0] == '/':
+			// Close the named element and anything left open inside it;
+			// end tags of elements already closed by their value are
+			// ignored
+			name := tag[1:]
+			for j := len(stack) - 1; j > 0; j-- {
+				if stack[j].name == name {
+					stack = stack[:j]
+					break
+				}
+			}
+		default:
+			node := &ofxNode{name: strings.TrimSuffix(tag, "/"), line: line}
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, node)
+			if !strings.HasSuffix(tag, "/") {
+				stack = append(stack, node)
+			}
+		}
+	}
+
+	ofx := root.child("OFX")
+	if ofx == nil {
+		return nil, fmt.Errorf("no <OFX> element found")
+	}
+	return ofx, nil
+}
+
+// parseOFXDate reads the date part of an OFX datetime such as
+// "20240614120000.000[-5:EST]".
+func parseOFXDate(s string) (string, error) {
+	if len(s) >= 8 {
+		if t, err := time.Parse("20060102", s[:8]); err == nil {
+			return t.Format(dateLayout), nil
+		}
+	}
+	return "", fmt.Errorf("invalid date %q", s)
+}
+
+// fetchFMPCusip looks up the ticker for a CUSIP.
+func fetchFMPCusip(cusip, apiKey string) (string, error) {
+	url := fmt.Sprintf("https://financialmodelingprep.com/api/v3/cusip/%s?apikey=%s", cusip, apiKey)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up CUSIP from FMP: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("FMP CUSIP API returned status %d", resp.StatusCode)
+	}
+
+	var fmpResp []struct {
+		Ticker string `json:"ticker"`
+		Cusip  string `json:"cusip"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&fmpResp); err != nil {
+		return "", fmt.Errorf("failed to parse FMP CUSIP response: %v", err)
+	}
+	if len(fmpResp) == 0 || fmpResp[0].Ticker == "" {
+		return "", fmt.Errorf("no ticker found for CUSIP %s", cusip)
+	}
+	return strings.ToUpper(fmpResp[0].Ticker), nil
+}
+
+// ofxSecurities maps SECID (type:id) to ticker from the SECLIST. A nil
+// lookupCUSIP leaves CUSIPs without a listed ticker unresolved.
+type ofxSecurities struct {
+	tickers     map[string]string
+	lookupCUSIP func(cusip string) (string, error)
+}
+
+func (s *ofxSecurities) ticker(secid *ofxNode) (string, error) {
+	idType, id := strings.ToUpper(secid.text("UNIQUEIDTYPE")), strings.ToUpper(secid.text("UNIQUEID"))
+	if id == "" {
+		return "", fmt.Errorf("security has no UNIQUEID")
+	}
+	if t, ok := s.tickers[idType+":"+id]; ok {
+		return normalizeSymbol(t)
+	}
+	if idType == "TICKER" {
+		return normalizeSymbol(id)
+	}
+	if idType == "CUSIP" && s.lookupCUSIP != nil {
+		t, err := s.lookupCUSIP(id)
+		if err != nil {
+			return "", err
+		}
+		s.tickers[idType+":"+id] = t
+		return normalizeSymbol(t)
+	}
+	return "", fmt.Errorf("no ticker for %s %s", idType, id)
+}
+
+// ofxAmount reads a numeric element; missing elements are zero.
+func ofxAmount(n *ofxNode, name string) (float64, error) {
+	return parseImportNumber(n.text(name))
+}
+
+// parseImportOFX reads the positions (INVPOSLIST) or the buy, sell,
+// income and reinvest transactions (INVTRANLIST) of every investment
+// statement in an OFX or QFX file. Securities are identified by the
+// SECLIST ticker, by a TICKER SECID, or by looking up their CUSIP. Line
+// numbers are those of each position or transaction element.
+func parseImportOFX(r io.Reader, kind string, lookupCUSIP func(string) (string, error)) (*importRecords, error) {
+	ofx, err := parseOFX(r)
+	if err != nil {
+		return nil, err
+	}
+	statements := ofx.all("INVSTMTRS")
+	if len(statements) == 0 {
+		return nil, fmt.Errorf("no investment statement (INVSTMTRS) found in OFX file")
+	}
+
+	secs := &ofxSecurities{tickers: map[string]string{}, lookupCUSIP: lookupCUSIP}
+	for _, list := range ofx.all("SECLIST") {
+		for _, info := range list.children {
+			secid := info.child("SECINFO").child("SECID")
+			ticker := strings.ToUpper(info.text("SECINFO", "TICKER"))
+			if secid != nil && ticker != "" {
+				secs.tickers[strings.ToUpper(secid.text("UNIQUEIDTYPE"))+":"+strings.ToUpper(secid.text("UNIQUEID"))] = ticker
+			}
+		}
+	}
+
+	result := &importRecords{}
+	if kind == importHoldings {
+		found := false
+		for _, stmt := range statements {
+			list := stmt.child("INVPOSLIST")
+			if list == nil {
+				continue
+			}
+			found = true
+			for _, pos := range list.children {
+				inv := pos.child("INVPOS")
+				if inv == nil {
+					continue
+				}
+				ticker, err := secs.ticker(inv.child("SECID"))
+				if err != nil {
+					result.conflict(pos.line, inv.text("SECID", "UNIQUEID"), err.Error())
+					continue
+				}
+				units, err := ofxAmount(inv, "UNITS")
+				if err != nil {
+					result.conflict(pos.line, ticker, err.Error())
+					continue
+				}
+				if strings.ToUpper(inv.text("POSTYPE")) == "SHORT" && units > 0 {
+					units = -units
+				}
+				result.holdings = append(result.holdings, importedHolding{Line: pos.line, Ticker: ticker, Shares: units})
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no positions (INVPOSLIST) found in OFX file")
+		}
+		return result, nil
+	}
+
+	found := false
+	for _, stmt := range statements {
+		list := stmt.child("INVTRANLIST")
+		if list == nil {
+			continue
+		}
+		found = true
+		for _, tran := range list.children {
+			if tran.name == "DTSTART" || tran.name == "DTEND" {
+				continue
+			}
+			body := tran
+			switch tran.name {
+			case "BUYSTOCK", "BUYMF", "BUYOTHER":
+				body = tran.child("INVBUY")
+			case "SELLSTOCK", "SELLMF", "SELLOTHER":
+				body = tran.child("INVSELL")
+			case "INCOME", "REINVEST":
+			case "INVBANKTRAN":
+				result.skip(tran.line, "", "not a security")
+				continue
+			default:
+				result.skip(tran.line, tran.text("SECID", "UNIQUEID"), fmt.Sprintf("unsupported transaction %s", tran.name))
+				continue
+			}
+			if body == nil || body.child("SECID") == nil {
+				result.conflict(tran.line, "", fmt.Sprintf("%s has no security", tran.name))
+				continue
+			}
+
+			ticker, err := secs.ticker(body.child("SECID"))
+			if err != nil {
+				result.conflict(tran.line, body.text("SECID", "UNIQUEID"), err.Error())
+				continue
+			}
+			date, err := parseOFXDate(body.text("INVTRAN", "DTTRADE"))
+			if err != nil {
+				result.conflict(tran.line, ticker, err.Error())
+				continue
+			}
+
+			// Only dividends are income here, but a reinvested capital
+			// gain still buys shares
+			dividend := true
+			if tran.name == "INCOME" || tran.name == "REINVEST" {
+				if incomeType := strings.ToUpper(body.text("INCOMETYPE")); incomeType != "DIV" {
+					result.skip(tran.line, ticker, fmt.Sprintf("unsupported income type %s", incomeType))
+					if tran.name == "INCOME" {
+						continue
+					}
+					dividend = false
+				}
+			}
+
+			units, err := ofxAmount(body, "UNITS")
+			var price, total, commission, fees float64
+			if err == nil {
+				price, err = ofxAmount(body, "UNITPRICE")
+			}
+			if err == nil {
+				total, err = ofxAmount(body, "TOTAL")
+			}
+			if err == nil {
+				commission, err = ofxAmount(body, "COMMISSION")
+			}
+			if err == nil {
+				fees, err = ofxAmount(body, "FEES")
+			}
+			if err != nil {
+				result.conflict(tran.line, ticker, err.Error())
+				continue
+			}
+
+			add := func(req TransactionRequest) {
+				result.transactions = append(result.transactions, importedTransaction{Line: tran.line, Request: req})
+			}
+			trade := TransactionRequest{
+				Ticker: ticker, TradeDate: date, Shares: abs(units), Price: abs(price),
+				Fees: abs(commission) + abs(fees), Amount: abs(total),
+			}
+			switch tran.name {
+			case "INCOME":
+				add(TransactionRequest{Ticker: ticker, Type: TransactionDividend, TradeDate: date, Amount: abs(total)})
+			case "REINVEST":
+				// A reinvested dividend is income followed by a purchase
+				if dividend {
+					add(TransactionRequest{Ticker: ticker, Type: TransactionDividend, TradeDate: date, Amount: abs(total)})
+				}
+				trade.Type = TransactionBuy
+				add(trade)
+			case "BUYSTOCK", "BUYMF", "BUYOTHER":
+				trade.Type = TransactionBuy
+				add(trade)
+			default:
+				trade.Type = TransactionSell
+				add(trade)
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no transactions (INVTRANLIST) found in OFX file")
+	}
+	return result, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stubCUSIPs resolves the CUSIPs used in testdata and counts lookups.
+type stubCUSIPs struct {
+	calls map[string]int
+}
+
+func (s *stubCUSIPs) lookup(cusip string) (string, error) {
+	s.calls[cusip]++
+	switch cusip {
+	case "922908769":
+		return "vti", nil
+	case "191216100":
+		return "KO", nil
+	}
+	return "", fmt.Errorf("no ticker found for CUSIP %s", cusip)
+}
+
+func TestParseImportOFX(t *testing.T) {
+	row := func(line int, ticker, message string) ImportRow {
+		return ImportRow{Lines: []int{line}, Ticker: ticker, Message: message}
+	}
+	tx := func(line int, req TransactionRequest) importedTransaction {
+		return importedTransaction{Line: line, Request: req}
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		kind    string
+		want    *importRecords
+		lookups map[string]int
+	}{
+		{
+			name: "SGML positions",
+			file: "testdata/brokerage_v1.ofx",
+			kind: importHoldings,
+			want: &importRecords{
+				holdings: []importedHolding{
+					{Line: 161, Ticker: "KO", Shares: 100},
+					{Line: 175, Ticker: "BRK-B", Shares: 20},
+					{Line: 189, Ticker: "VTI", Shares: 40.12},
+				},
+				conflicts: []ImportRow{row(203, "000000000", "no ticker found for CUSIP 000000000")},
+			},
+			lookups: map[string]int{"922908769": 1, "000000000": 1},
+		},
+		{
+			name: "SGML transactions",
+			file: "testdata/brokerage_v1.ofx",
+			kind: importTransactions,
+			want: &importRecords{
+				transactions: []importedTransaction{
+					tx(39, TransactionRequest{Ticker: "KO", Type: TransactionBuy, TradeDate: "2024-01-15", Shares: 100, Price: 58.50, Fees: 4.95, Amount: 5854.95}),
+					tx(58, TransactionRequest{Ticker: "BRK-B", Type: TransactionSell, TradeDate: "2024-03-01", Shares: 5, Price: 405, Fees: 0.12, Amount: 2024.88}),
+					tx(78, TransactionRequest{Ticker: "KO", Type: TransactionDividend, TradeDate: "2024-04-01", Amount: 48.50}),
+					tx(106, TransactionRequest{Ticker: "VTI", Type: TransactionDividend, TradeDate: "2024-05-01", Amount: 30}),
+					tx(106, TransactionRequest{Ticker: "VTI", Type: TransactionBuy, TradeDate: "2024-05-01", Shares: 0.12, Price: 250, Amount: 30}),
+					tx(121, TransactionRequest{Ticker: "VTI", Type: TransactionBuy, TradeDate: "2024-05-15", Shares: 0.1, Price: 250, Amount: 25}),
+				},
+				skipped: []ImportRow{
+					row(92, "VTI", "unsupported income type CGLONG"),
+					row(121, "VTI", "unsupported income type CGLONG"),
+					row(136, "", "not a security"),
+				},
+				conflicts: []ImportRow{row(145, "000000000", "no ticker found for CUSIP 000000000")},
+			},
+			lookups: map[string]int{"922908769": 1, "000000000": 1},
+		},
+		{
+			name: "XML positions",
+			file: "testdata/brokerage_v2.qfx",
+			kind: importHoldings,
+			want: &importRecords{
+				holdings: []importedHolding{
+					{Line: 65, Ticker: "VTI", Shares: 10},
+					{Line: 77, Ticker: "RY.TO", Shares: 15},
+				},
+			},
+			lookups: map[string]int{"922908769": 1},
+		},
+		{
+			name: "XML transactions",
+			file: "testdata/brokerage_v2.qfx",
+			kind: importTransactions,
+			want: &importRecords{
+				transactions: []importedTransaction{
+					tx(13, TransactionRequest{Ticker: "VTI", Type: TransactionBuy, TradeDate: "2024-02-10", Shares: 10, Price: 240, Amount: 2400}),
+					tx(29, TransactionRequest{Ticker: "VTI", Type: TransactionDividend, TradeDate: "2024-03-28", Amount: 8.25}),
+				},
+				skipped: []ImportRow{
+					row(41, "VTI", "unsupported income type INTEREST"),
+					row(53, "191216100", "unsupported transaction TRANSFER"),
+				},
+			},
+			lookups: map[string]int{"922908769": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			stub := &stubCUSIPs{calls: map[string]int{}}
+			got, err := parseImportOFX(f, tt.kind, stub.lookup)
+			if err != nil {
+				t.Fatalf("parseImportOFX: %v", err)
+			}
+			if !reflect.DeepEqual(got.holdings, tt.want.holdings) {
+				t.Errorf("holdings = %+v, want %+v", got.holdings, tt.want.holdings)
+			}
+			if !reflect.DeepEqual(got.transactions, tt.want.transactions) {
+				t.Errorf("transactions = %+v, want %+v", got.transactions, tt.want.transactions)
+			}
+			if !reflect.DeepEqual(got.skipped, tt.want.skipped) {
+				t.Errorf("skipped = %+v, want %+v", got.skipped, tt.want.skipped)
+			}
+			if !reflect.DeepEqual(got.conflicts, tt.want.conflicts) {
+				t.Errorf("conflicts = %+v, want %+v", got.conflicts, tt.want.conflicts)
+			}
+			if !reflect.DeepEqual(stub.calls, tt.lookups) {
+				t.Errorf("CUSIP lookups = %v, want %v", stub.calls, tt.lookups)
+			}
+		})
+	}
+}
+
+func TestParseImportOFXMissingSections(t *testing.T) {
+	doc := "<OFX><INVSTMTMSGSRSV1><INVSTMTTRNRS><INVSTMTRS><CURDEF>USD</INVSTMTRS></INVSTMTTRNRS></INVSTMTMSGSRSV1></OFX>"
+	for _, kind := range []string{importHoldings, importTransactions} {
+		if _, err := parseImportOFX(strings.NewReader(doc), kind, nil); err == nil {
+			t.Errorf("%s: expected an error for a statement without the list", kind)
+		}
+	}
+}
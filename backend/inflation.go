@@ -0,0 +1,312 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bundledCPI is the BLS CPI-U (US city average, all items, 1982-84=100)
+// annual average for each year. Set CPI_CSV_PATH to a file in the same
+// year,cpi format to use a different or newer series.
+//
+//go:embed data/cpi_u_annual.csv
+var bundledCPI string
+
+// cpiTrendYears is the window of the trailing inflation rate used to
+// extend the series past its last year and as the default projection
+// assumption.
+const cpiTrendYears = 10
+
+// cpiSeries is an annual price index. Trend is the trailing annual
+// inflation rate in percent.
+type cpiSeries struct {
+	values map[int]float64
+	years  []int
+	trend  float64
+	source string
+}
+
+var (
+	cpiOnce   sync.Once
+	cpiLoaded *cpiSeries
+	cpiErr    error
+)
+
+// parseCPI reads year,cpi rows. A header row and extra columns are
+// ignored.
+func parseCPI(r io.Reader, source string) (*cpiSeries, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	s := &cpiSeries{values: map[int]float64{}, source: source}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CPI line %d: %v", line, err)
+		}
+		if len(record) < 2 {
+			continue
+		}
+		year, err := strconv.Atoi(strings.TrimSpace(record[0]))
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("invalid CPI year on line %d: %q", line, record[0])
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("invalid CPI value on line %d: %q", line, record[1])
+		}
+		s.values[year] = value
+		s.years = append(s.years, year)
+	}
+	if len(s.years) < 2 {
+		return nil, fmt.Errorf("CPI series needs at least two years")
+	}
+	sort.Ints(s.years)
+
+	last := s.years[len(s.years)-1]
+	for n := cpiTrendYears; n >= 1; n-- {
+		if rate, ok := s.inflation(last, n); ok {
+			s.trend = rate
+			break
+		}
+	}
+	return s, nil
+}
+
+// loadCPI parses the CPI series once, from CPI_CSV_PATH if set.
+func loadCPI() (*cpiSeries, error) {
+	cpiOnce.Do(func() {
+		path := os.Getenv("CPI_CSV_PATH")
+		if path == "" {
+			cpiLoaded, cpiErr = parseCPI(strings.NewReader(bundledCPI), "bundled CPI-U annual averages")
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			cpiErr = fmt.Errorf("failed to open CPI file: %v", err)
+			return
+		}
+		defer f.Close()
+		cpiLoaded, cpiErr = parseCPI(f, path)
+	})
+	return cpiLoaded, cpiErr
+}
+
+func (s *cpiSeries) lastYear() int {
+	return s.years[len(s.years)-1]
+}
+
+// at returns the index for year. Years outside the series, or missing
+// from it, are extended from the nearest earlier year (or the first year)
+// at the trend rate and reported as estimated.
+func (s *cpiSeries) at(year int) (value float64, estimated bool) {
+	if v, ok := s.values[year]; ok {
+		return v, false
+	}
+	from := s.years[0]
+	for _, y := range s.years {
+		if y > year {
+			break
+		}
+		from = y
+	}
+	return s.values[from] * math.Pow(1+s.trend/100, float64(year-from)), true
+}
+
+// inflation returns the compound annual inflation rate, in percent, over
+// the given number of years ending with endYear, using recorded values
+// only.
+func (s *cpiSeries) inflation(endYear, years int) (float64, bool) {
+	start, ok1 := s.values[endYear-years]
+	end, ok2 := s.values[endYear]
+	if years <= 0 || !ok1 || !ok2 {
+		return 0, false
+	}
+	return (math.Pow(end/start, 1/float64(years)) - 1) * 100, true
+}
+
+// toReal converts an amount in year's dollars into baseYear's dollars.
+func (s *cpiSeries) toReal(amount float64, year, baseYear int) float64 {
+	from, _ := s.at(year)
+	to, _ := s.at(baseYear)
+	return amount * to / from
+}
+
+// RealIncomeYear is dividends received in a calendar year, nominal and in
+// base-year dollars. Inflation is that year's CPI change in percent.
+type RealIncomeYear struct {
+	Year      int     `json:"year"`
+	Nominal   float64 `json:"nominal"`
+	Real      float64 `json:"real"`
+	Inflation float64 `json:"inflation"`
+	Estimated bool    `json:"estimated"`
+}
+
+// GrowthVsInflation compares a holding's dividend CAGR with CPI inflation
+// over the same window. Growth fields are null when the holding lacks the
+// dividend history for the window.
+type GrowthVsInflation struct {
+	Years          int      `json:"years"`
+	StartYear      int      `json:"start_year"`
+	EndYear        int      `json:"end_year"`
+	DividendGrowth *float64 `json:"dividend_growth"`
+	Inflation      float64  `json:"inflation"`
+	RealGrowth     *float64 `json:"real_growth"`
+	BeatInflation  *bool    `json:"beat_inflation"`
+}
+
+type HoldingInflation struct {
+	Ticker string              `json:"ticker"`
+	Growth []GrowthVsInflation `json:"growth"`
+}
+
+// InflationReport gives income in BaseYear dollars. Income in years the
+// CPI series does not cover is deflated at AssumedInflation and flagged
+// estimated.
+type InflationReport struct {
+	BaseYear         int                `json:"base_year"`
+	CPISource        string             `json:"cpi_source"`
+	CPILastYear      int                `json:"cpi_last_year"`
+	AssumedInflation float64            `json:"assumed_inflation"`
+	PortfolioID      string             `json:"portfolio_id,omitempty"`
+	BaseCurrency     string             `json:"base_currency"`
+	Rates            []FXRate           `json:"rates"`
+	AnnualIncome     float64            `json:"annual_income"`
+	MonthlyIncome    float64            `json:"monthly_income"`
+	Realized         []RealIncomeYear   `json:"realized"`
+	Holdings         []HoldingInflation `json:"holdings"`
+	Warnings         []string           `json:"warnings"`
+}
+
+// inflationWindows are the look-backs, in years, that each holding's
+// dividend growth is compared over.
+var inflationWindows = []int{1, 5, 10}
+
+func compareGrowth(events []DividendEvent, cpi *cpiSeries, endYear int) []GrowthVsInflation {
+	comparisons := []GrowthVsInflation{}
+	for _, n := range inflationWindows {
+		inflation, ok := cpi.inflation(endYear, n)
+		if !ok {
+			continue
+		}
+		g := GrowthVsInflation{Years: n, StartYear: endYear - n, EndYear: endYear, Inflation: roundTo(inflation, 2)}
+		if growth, ok := dividendCAGR(events, endYear, n); ok {
+			realGrowth := ((1+growth/100)/(1+inflation/100) - 1) * 100
+			beat := growth > inflation
+			growth, realGrowth = roundTo(growth, 2), roundTo(realGrowth, 2)
+			g.DividendGrowth, g.RealGrowth, g.BeatInflation = &growth, &realGrowth, &beat
+		}
+		comparisons = append(comparisons, g)
+	}
+	return comparisons
+}
+
+func getInflationReport(userID, portfolioID, apiKey string) (*InflationReport, error) {
+	cpi, err := loadCPI()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	report := &InflationReport{
+		BaseYear:         now.Year(),
+		CPISource:        cpi.source,
+		CPILastYear:      cpi.lastYear(),
+		AssumedInflation: roundTo(cpi.trend, 2),
+		PortfolioID:      portfolioID,
+		Realized:         []RealIncomeYear{},
+		Holdings:         []HoldingInflation{},
+		Warnings:         []string{},
+	}
+
+	holdings, conv, err := getBaseHoldings(userID, portfolioID, "")
+	if err != nil {
+		return nil, err
+	}
+	transactions, err := getTransactions(userID, TransactionFilter{PortfolioID: portfolioID})
+	if err != nil {
+		return nil, err
+	}
+	lfx, err := newLedgerFX(userID, conv, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if transactions, err = lfx.convertTransactions(transactions); err != nil {
+		return nil, err
+	}
+	byYear := map[int]float64{}
+	for _, t := range transactions {
+		if t.Type != TransactionDividend {
+			continue
+		}
+		if d, err := time.Parse(dateLayout, t.TradeDate); err == nil {
+			byYear[d.Year()] += t.Amount
+		}
+	}
+	var years []int
+	for y := range byYear {
+		years = append(years, y)
+	}
+	sort.Ints(years)
+	for _, y := range years {
+		index, estimated := cpi.at(y)
+		prev, prevEstimated := cpi.at(y - 1)
+		report.Realized = append(report.Realized, RealIncomeYear{
+			Year:      y,
+			Nominal:   roundTo(byYear[y], 2),
+			Real:      roundTo(cpi.toReal(byYear[y], y, report.BaseYear), 2),
+			Inflation: roundTo((index/prev-1)*100, 2),
+			Estimated: estimated || prevEstimated,
+		})
+	}
+
+	// Compare complete calendar years the CPI series covers
+	endYear := now.Year() - 1
+	if cpi.lastYear() < endYear {
+		endYear = cpi.lastYear()
+	}
+	for _, p := range aggregatePositions(holdings) {
+		report.AnnualIncome += p.MonthlyDividend * 12
+		events, err := getDividendHistory(p.Ticker, apiKey)
+		if err != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("no dividend history for %s: %v", p.Ticker, err))
+			continue
+		}
+		report.Holdings = append(report.Holdings, HoldingInflation{Ticker: p.Ticker, Growth: compareGrowth(events, cpi, endYear)})
+	}
+	report.BaseCurrency, report.Rates = conv.base, conv.used
+	report.MonthlyIncome = roundTo(report.AnnualIncome/12, 2)
+	report.AnnualIncome = roundTo(report.AnnualIncome, 2)
+
+	return report, nil
+}
+
+func registerInflationRoutes(protected *gin.RouterGroup, apiKey string) {
+	protected.GET("/inflation", func(c *gin.Context) {
+		report, err := getInflationReport(c.GetString("user_id"), c.Query("portfolio_id"), apiKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+}
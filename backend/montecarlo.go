@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Monte Carlo limits. Runs stop early once monteCarloTimeLimit is spent and
+// the result is marked truncated.
+const (
+	defaultMonteCarloRuns = 1000
+	monteCarloTimeLimit   = 10 * time.Second
+	monteCarloLookback    = 10 // years of history sampled from
+)
+
+// Percentiles of one quantity across all runs.
+type PercentileBand struct {
+	P10 float64 `json:"p10"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+}
+
+// MonteCarloYear is the spread of outcomes at the end of a projected year.
+// Value includes dividends held as cash when DRIP is off. Income bands are
+// gross except the net ones, after withholding. Real bands are in today's
+// dollars.
+type MonteCarloYear struct {
+	Year             int            `json:"year"`
+	Value            PercentileBand `json:"value"`
+	AnnualIncome     PercentileBand `json:"annual_income"`
+	MonthlyIncome    PercentileBand `json:"monthly_income"`
+	NetAnnualIncome  PercentileBand `json:"net_annual_income"`
+	NetMonthlyIncome PercentileBand `json:"net_monthly_income"`
+	RealValue        PercentileBand `json:"real_value"`
+	RealAnnualIncome PercentileBand `json:"real_annual_income"`
+}
+
+type MonteCarloResult struct {
+	ProjectionRequest
+	Seed          int64                  `json:"seed"`
+	RunsCompleted int                    `json:"runs_completed"`
+	Truncated     bool                   `json:"truncated"`
+	Holdings      []ProjectionAssumption `json:"holdings"`
+	Bands         []MonteCarloYear       `json:"bands"`
+	Warnings      []string               `json:"warnings"`
+}
+
+// holdingHistory holds the samples drawn for one holding: monthly price
+// returns keyed by "2006-01" and year-over-year dividend changes keyed by
+// year, both in percent.
+type holdingHistory struct {
+	monthly map[string]float64
+	months  []string
+	growth  map[int]float64
+	years   []int
+}
+
+// monthlyReturns turns daily closes into month-end to month-end returns.
+// The last month is left out because it may still be in progress.
+func monthlyReturns(bars []PriceBar) map[string]float64 {
+	var months []string
+	closes := map[string]float64{}
+	for _, b := range bars {
+		if len(b.Date) < 7 || b.Close <= 0 {
+			continue
+		}
+		month := b.Date[:7]
+		if _, ok := closes[month]; !ok {
+			months = append(months, month)
+		}
+		closes[month] = b.Close
+	}
+
+	returns := map[string]float64{}
+	for i := 1; i < len(months)-1; i++ {
+		returns[months[i]] = (closes[months[i]]/closes[months[i-1]] - 1) * 100
+	}
+	return returns
+}
+
+// dividendChanges returns year-over-year changes in calendar-year dividends
+// for complete years through lastYear. The first year with dividends is
+// skipped because it is usually partial; a year paying nothing after a
+// year that paid counts as a -100% cut.
+func dividendChanges(events []DividendEvent, firstYear, lastYear int) map[int]float64 {
+	totals := annualDividends(events)
+	earliest := lastYear + 1
+	for y := range totals {
+		if y < earliest {
+			earliest = y
+		}
+	}
+
+	changes := map[int]float64{}
+	for y := firstYear; y <= lastYear; y++ {
+		if y-1 <= earliest || totals[y-1] <= 0 {
+			continue
+		}
+		changes[y] = (totals[y]/totals[y-1] - 1) * 100
+	}
+	return changes
+}
+
+func loadHoldingHistory(symbol string, now time.Time, apiKey string) (holdingHistory, []string) {
+	h := holdingHistory{monthly: map[string]float64{}, growth: map[int]float64{}}
+	var warnings []string
+
+	from := now.AddDate(-monteCarloLookback, 0, 0)
+	bars, err := getPriceHistory(symbol, from, now, apiKey)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("no price history for %s, using price_growth: %v", symbol, err))
+	} else {
+		h.monthly = monthlyReturns(bars)
+	}
+
+	events, err := getDividendHistory(symbol, apiKey)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("no dividend history for %s, using its dividend growth assumption: %v", symbol, err))
+	} else {
+		h.growth = dividendChanges(events, now.Year()-monteCarloLookback, now.Year()-1)
+	}
+
+	for m := range h.monthly {
+		h.months = append(h.months, m)
+	}
+	sort.Strings(h.months)
+	for y := range h.growth {
+		h.years = append(h.years, y)
+	}
+	sort.Ints(h.years)
+	return h, warnings
+}
+
+// percentile interpolates linearly between the closest ranks of sorted.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+func percentileBand(values []float64) PercentileBand {
+	sort.Float64s(values)
+	return PercentileBand{
+		P10: roundTo(percentile(values, 10), 2),
+		P50: roundTo(percentile(values, 50), 2),
+		P90: roundTo(percentile(values, 90), 2),
+	}
+}
+
+func scaleBand(b PercentileBand, factor float64) PercentileBand {
+	return PercentileBand{
+		P10: roundTo(b.P10*factor, 2),
+		P50: roundTo(b.P50*factor, 2),
+		P90: roundTo(b.P90*factor, 2),
+	}
+}
+
+// runMonteCarlo repeats projectPath with rates bootstrapped from history.
+// Each projected year draws twelve historical months and one historical
+// dividend year shared by all holdings, which keeps the correlation
+// between holdings; a holding with no data for the drawn month or year
+// draws from its own history instead, and one with no history at all
+// keeps its deterministic assumption.
+func runMonteCarlo(holdings []projectionHolding, histories []holdingHistory, req ProjectionRequest, runs int, seed int64) ([]MonteCarloYear, int, bool) {
+	rng := rand.New(rand.NewSource(seed))
+
+	monthSet, yearSet := map[string]bool{}, map[int]bool{}
+	for _, h := range histories {
+		for _, m := range h.months {
+			monthSet[m] = true
+		}
+		for _, y := range h.years {
+			yearSet[y] = true
+		}
+	}
+	var months []string
+	for m := range monthSet {
+		months = append(months, m)
+	}
+	sort.Strings(months)
+	var years []int
+	for y := range yearSet {
+		years = append(years, y)
+	}
+	sort.Ints(years)
+
+	values := make([][]float64, req.Years+1)
+	incomes := make([][]float64, req.Years+1)
+	netIncomes := make([][]float64, req.Years+1)
+
+	start := time.Now()
+	completed, truncated := 0, false
+	for run := 0; run < runs; run++ {
+		if run > 0 && time.Since(start) > monteCarloTimeLimit {
+			truncated = true
+			break
+		}
+
+		drawnYear := -1
+		var drawnMonths [12]string
+		var drawnDividendYear int
+		rates := func(y, i int) (float64, float64) {
+			if y != drawnYear {
+				drawnYear = y
+				for m := range drawnMonths {
+					if len(months) > 0 {
+						drawnMonths[m] = months[rng.Intn(len(months))]
+					}
+				}
+				if len(years) > 0 {
+					drawnDividendYear = years[rng.Intn(len(years))]
+				}
+			}
+
+			h := histories[i]
+			price := req.PriceGrowth
+			if len(h.months) > 0 {
+				factor := 1.0
+				for _, m := range drawnMonths {
+					r, ok := h.monthly[m]
+					if !ok {
+						r = h.monthly[h.months[rng.Intn(len(h.months))]]
+					}
+					factor *= 1 + r/100
+				}
+				price = (factor - 1) * 100
+			}
+
+			dividend := holdings[i].DividendGrowth
+			if len(h.years) > 0 {
+				g, ok := h.growth[drawnDividendYear]
+				if !ok {
+					g = h.growth[h.years[rng.Intn(len(h.years))]]
+				}
+				dividend = g
+			}
+			return price, dividend
+		}
+
+		for _, p := range projectPath(holdings, req, rates) {
+			values[p.Year] = append(values[p.Year], p.Value+p.Cash)
+			incomes[p.Year] = append(incomes[p.Year], p.AnnualIncome)
+			netIncomes[p.Year] = append(netIncomes[p.Year], p.NetAnnualIncome)
+		}
+		completed++
+	}
+
+	bands := make([]MonteCarloYear, 0, req.Years+1)
+	for y := 0; y <= req.Years; y++ {
+		income := percentileBand(incomes[y])
+		netIncome := percentileBand(netIncomes[y])
+		value := percentileBand(values[y])
+		deflator := 1.0
+		if req.Inflation != nil {
+			deflator = math.Pow(1+*req.Inflation/100, float64(y))
+		}
+		bands = append(bands, MonteCarloYear{
+			Year:             y,
+			Value:            value,
+			AnnualIncome:     income,
+			MonthlyIncome:    scaleBand(income, 1.0/12),
+			NetAnnualIncome:  netIncome,
+			NetMonthlyIncome: scaleBand(netIncome, 1.0/12),
+			RealValue:        scaleBand(value, 1/deflator),
+			RealAnnualIncome: scaleBand(income, 1/deflator),
+		})
+	}
+	return bands, completed, truncated
+}
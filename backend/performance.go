@@ -0,0 +1,473 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReturnBreakdown splits a return into its price and income parts. Values
+// are percentages; Annualized is only reported for periods of a year or more.
+type ReturnBreakdown struct {
+	Total      float64  `json:"total"`
+	Price      float64  `json:"price"`
+	Income     float64  `json:"income"`
+	Annualized *float64 `json:"annualized,omitempty"`
+}
+
+// PerformanceResult describes how a holding or portfolio did over a period.
+// NetContributions is money put in through buys less money taken out through
+// sells; PriceGain is the change in value not explained by contributions.
+type PerformanceResult struct {
+	Ticker           string           `json:"ticker,omitempty"`
+	StartValue       float64          `json:"start_value"`
+	EndValue         float64          `json:"end_value"`
+	NetContributions float64          `json:"net_contributions"`
+	Dividends        float64          `json:"dividends"`
+	PriceGain        float64          `json:"price_gain"`
+	TotalGain        float64          `json:"total_gain"`
+	TWR              ReturnBreakdown  `json:"twr"`
+	MWR              *ReturnBreakdown `json:"mwr"`
+}
+
+// PortfolioPerformance is in the base currency; Rates are those used to
+// convert the ledger and prices.
+type PortfolioPerformance struct {
+	From         string              `json:"from"`
+	To           string              `json:"to"`
+	PortfolioID  string              `json:"portfolio_id,omitempty"`
+	BaseCurrency string              `json:"base_currency"`
+	Rates        []FXRate            `json:"rates"`
+	Portfolio    PerformanceResult   `json:"portfolio"`
+	Holdings     []PerformanceResult `json:"holdings"`
+}
+
+// priceSeries holds a symbol's daily closes, oldest first.
+type priceSeries struct {
+	dates  []string
+	closes []float64
+}
+
+func newPriceSeries(bars []PriceBar) priceSeries {
+	s := priceSeries{dates: make([]string, len(bars)), closes: make([]float64, len(bars))}
+	for i, b := range bars {
+		s.dates[i] = b.Date
+		s.closes[i] = b.Close
+	}
+	return s
+}
+
+// scale returns a copy of s with every close multiplied by factor.
+func (s priceSeries) scale(factor float64) priceSeries {
+	scaled := priceSeries{dates: s.dates, closes: make([]float64, len(s.closes))}
+	for i, c := range s.closes {
+		scaled.closes[i] = c * factor
+	}
+	return scaled
+}
+
+// closeOn returns the last close on or before date.
+func (s priceSeries) closeOn(date string) (float64, bool) {
+	i := sort.SearchStrings(s.dates, date)
+	if i < len(s.dates) && s.dates[i] == date {
+		return s.closes[i], true
+	}
+	if i == 0 {
+		return 0, false
+	}
+	return s.closes[i-1], true
+}
+
+// priceLookback makes sure a close is available for a start date that falls
+// on a weekend or holiday.
+const priceLookback = 7
+
+// loadPriceSeries loads closes for every symbol between from and to.
+func loadPriceSeries(symbols []string, from, to time.Time, apiKey string) (map[string]priceSeries, error) {
+	series := make(map[string]priceSeries, len(symbols))
+	for _, symbol := range symbols {
+		bars, err := getPriceHistory(symbol, from.AddDate(0, 0, -priceLookback), to, apiKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load prices for %s: %v", symbol, err)
+		}
+		series[symbol] = newPriceSeries(bars)
+	}
+	return series, nil
+}
+
+// ledger replays transactions (sorted by trade date) to value positions on
+// any date.
+type ledger struct {
+	transactions []Transaction
+	prices       map[string]priceSeries
+}
+
+func (l *ledger) tickers() []string {
+	seen := map[string]bool{}
+	var tickers []string
+	for _, t := range l.transactions {
+		if !seen[t.Ticker] {
+			seen[t.Ticker] = true
+			tickers = append(tickers, t.Ticker)
+		}
+	}
+	sort.Strings(tickers)
+	return tickers
+}
+
+// positions returns shares held per ticker after the trades dated before
+// date, plus those dated on date when inclusive is set.
+func (l *ledger) positions(date string, inclusive bool) map[string]float64 {
+	shares := map[string]float64{}
+	for _, t := range l.transactions {
+		if t.TradeDate > date || (!inclusive && t.TradeDate == date) {
+			break
+		}
+		switch t.Type {
+		case TransactionBuy:
+			shares[t.Ticker] += t.Shares
+		case TransactionSell:
+			shares[t.Ticker] -= t.Shares
+		}
+	}
+	return shares
+}
+
+// priceOn returns the close for ticker on date, falling back to the price
+// of the latest trade on or before date when no price history is available.
+func (l *ledger) priceOn(ticker, date string) float64 {
+	if p, ok := l.prices[ticker].closeOn(date); ok {
+		return p
+	}
+	var last float64
+	for _, t := range l.transactions {
+		if t.TradeDate > date {
+			break
+		}
+		if t.Ticker == ticker && t.Price > 0 {
+			last = t.Price
+		}
+	}
+	return last
+}
+
+// value is the market value at date's close of the positions described by
+// positions(date, inclusive).
+func (l *ledger) value(date string, inclusive bool) float64 {
+	var total float64
+	for ticker, shares := range l.positions(date, inclusive) {
+		if shares > 0 {
+			total += shares * l.priceOn(ticker, date)
+		}
+	}
+	return total
+}
+
+// dividendsBetween sums dividends received in (after, through].
+func (l *ledger) dividendsBetween(after, through string) float64 {
+	var total float64
+	for _, t := range l.transactions {
+		if t.Type == TransactionDividend && t.TradeDate > after && t.TradeDate <= through {
+			total += t.Amount
+		}
+	}
+	return total
+}
+
+type cashFlow struct {
+	date   time.Time
+	amount float64
+}
+
+// xirr returns the annualized internal rate of return of flows, using
+// Newton's method with a bisection fallback. ok is false when the flows do
+// not have both signs, all fall on the same date, or no rate can be found.
+func xirr(flows []cashFlow) (float64, bool) {
+	if len(flows) < 2 {
+		return 0, false
+	}
+	var hasPos, hasNeg bool
+	for _, f := range flows {
+		hasPos = hasPos || f.amount > 0
+		hasNeg = hasNeg || f.amount < 0
+	}
+	if !hasPos || !hasNeg {
+		return 0, false
+	}
+
+	t0 := flows[0].date
+	spread := false
+	for _, f := range flows {
+		spread = spread || !f.date.Equal(t0)
+	}
+	// Every rate discounts same-day flows alike, so none is the answer
+	if !spread {
+		return 0, false
+	}
+	npv := func(rate float64) (float64, float64) {
+		var v, dv float64
+		for _, f := range flows {
+			years := f.date.Sub(t0).Hours() / 24 / 365
+			disc := math.Pow(1+rate, years)
+			v += f.amount / disc
+			dv -= years * f.amount / (disc * (1 + rate))
+		}
+		return v, dv
+	}
+
+	rate := 0.1
+	for i := 0; i < 100; i++ {
+		v, dv := npv(rate)
+		if math.Abs(v) < 1e-7 {
+			return rate, true
+		}
+		if dv == 0 {
+			// Newton's method has stalled; let bisection bracket the root
+			break
+		}
+		next := rate - v/dv
+		if next <= -1 || math.IsNaN(next) || math.IsInf(next, 0) {
+			break
+		}
+		if math.Abs(next-rate) < 1e-10 {
+			return next, true
+		}
+		rate = next
+	}
+
+	lo, hi := -0.9999, 100.0
+	vLo, _ := npv(lo)
+	vHi, _ := npv(hi)
+	if vLo*vHi > 0 {
+		return 0, false
+	}
+	for i := 0; i < 200; i++ {
+		mid := (lo + hi) / 2
+		vMid, _ := npv(mid)
+		if math.Abs(vMid) < 1e-7 || hi-lo < 1e-12 {
+			return mid, true
+		}
+		if vLo*vMid < 0 {
+			hi = mid
+		} else {
+			lo, vLo = mid, vMid
+		}
+	}
+	return (lo + hi) / 2, true
+}
+
+// computePerformance measures the transactions in l over (from, to].
+//
+// The time-weighted return chains sub-period returns split at every trade
+// date. Trades are assumed to settle at that day's close: a sub-period ends
+// with the value of the previous positions at the close and the next one
+// starts from the value after the day's trades. Dividends received in a
+// sub-period count towards the total return but not the price return.
+//
+// The money-weighted return is the XIRR of the starting value, the trades
+// and the dividends as seen by the investor, closed out by the end value.
+// Its price part is the XIRR of the same flows without dividends.
+func computePerformance(l *ledger, from, to time.Time) PerformanceResult {
+	fromDate, toDate := from.Format(dateLayout), to.Format(dateLayout)
+
+	result := PerformanceResult{
+		StartValue: l.value(fromDate, true),
+		EndValue:   l.value(toDate, true),
+	}
+
+	var tradeDates []string
+	totalFlows := []cashFlow{}
+	priceFlows := []cashFlow{}
+	if result.StartValue > 0 {
+		totalFlows = append(totalFlows, cashFlow{from, -result.StartValue})
+		priceFlows = append(priceFlows, cashFlow{from, -result.StartValue})
+	}
+
+	for _, t := range l.transactions {
+		if t.TradeDate <= fromDate || t.TradeDate > toDate {
+			continue
+		}
+		date, _ := time.Parse(dateLayout, t.TradeDate)
+		switch t.Type {
+		case TransactionBuy:
+			result.NetContributions += t.Amount
+			totalFlows = append(totalFlows, cashFlow{date, -t.Amount})
+			priceFlows = append(priceFlows, cashFlow{date, -t.Amount})
+		case TransactionSell:
+			result.NetContributions -= t.Amount
+			totalFlows = append(totalFlows, cashFlow{date, t.Amount})
+			priceFlows = append(priceFlows, cashFlow{date, t.Amount})
+		case TransactionDividend:
+			result.Dividends += t.Amount
+			totalFlows = append(totalFlows, cashFlow{date, t.Amount})
+			continue
+		}
+		if len(tradeDates) == 0 || tradeDates[len(tradeDates)-1] != t.TradeDate {
+			tradeDates = append(tradeDates, t.TradeDate)
+		}
+	}
+	if result.EndValue > 0 {
+		totalFlows = append(totalFlows, cashFlow{to, result.EndValue})
+		priceFlows = append(priceFlows, cashFlow{to, result.EndValue})
+	}
+
+	result.PriceGain = result.EndValue - result.StartValue - result.NetContributions
+	result.TotalGain = result.PriceGain + result.Dividends
+
+	// Time-weighted return
+	totalGrowth, priceGrowth := 1.0, 1.0
+	prevValue, prevDate := result.StartValue, fromDate
+	chain := func(endValue float64, date string) {
+		dividends := l.dividendsBetween(prevDate, date)
+		if prevValue > 0 {
+			totalGrowth *= (endValue + dividends) / prevValue
+			priceGrowth *= endValue / prevValue
+		}
+		prevValue, prevDate = l.value(date, true), date
+	}
+	for _, date := range tradeDates {
+		chain(l.value(date, false), date)
+	}
+	chain(result.EndValue, toDate)
+
+	years := to.Sub(from).Hours() / 24 / 365
+	result.TWR = ReturnBreakdown{
+		Total: roundTo((totalGrowth-1)*100, 2),
+		Price: roundTo((priceGrowth-1)*100, 2),
+	}
+	result.TWR.Income = roundTo(result.TWR.Total-result.TWR.Price, 2)
+	if years >= 1 {
+		annualized := roundTo((math.Pow(totalGrowth, 1/years)-1)*100, 2)
+		result.TWR.Annualized = &annualized
+	}
+
+	// Money-weighted return
+	if totalRate, ok := xirr(totalFlows); ok && years > 0 {
+		mwr := &ReturnBreakdown{Total: roundTo((math.Pow(1+totalRate, years)-1)*100, 2)}
+		if priceRate, ok := xirr(priceFlows); ok {
+			mwr.Price = roundTo((math.Pow(1+priceRate, years)-1)*100, 2)
+		}
+		mwr.Income = roundTo(mwr.Total-mwr.Price, 2)
+		if years >= 1 {
+			annualized := roundTo(totalRate*100, 2)
+			mwr.Annualized = &annualized
+		}
+		result.MWR = mwr
+	}
+
+	result.StartValue = roundTo(result.StartValue, 2)
+	result.EndValue = roundTo(result.EndValue, 2)
+	result.NetContributions = roundTo(result.NetContributions, 2)
+	result.Dividends = roundTo(result.Dividends, 2)
+	result.PriceGain = roundTo(result.PriceGain, 2)
+	result.TotalGain = roundTo(result.TotalGain, 2)
+	return result
+}
+
+// loadLedger reads the user's transactions up to `to` along with the price
+// history needed to value them from `from` onwards.
+func loadLedger(userID, portfolioID string, from, to time.Time, apiKey string) (*ledger, *ledgerFX, error) {
+	transactions, err := getTransactions(userID, TransactionFilter{PortfolioID: portfolioID, To: to})
+	if err != nil {
+		return nil, nil, err
+	}
+	lfx, err := newLedgerFX(userID, nil, apiKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if transactions, err = lfx.convertTransactions(transactions); err != nil {
+		return nil, nil, err
+	}
+
+	l := &ledger{transactions: transactions}
+	l.prices, err = loadPriceSeries(l.tickers(), from, to, apiKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	for ticker, series := range l.prices {
+		rate, err := lfx.rate(ticker)
+		if err != nil {
+			return nil, nil, err
+		}
+		l.prices[ticker] = series.scale(rate)
+	}
+	return l, lfx, nil
+}
+
+// filter returns a ledger restricted to one ticker, sharing prices.
+func (l *ledger) filter(ticker string) *ledger {
+	sub := &ledger{prices: l.prices}
+	for _, t := range l.transactions {
+		if t.Ticker == ticker {
+			sub.transactions = append(sub.transactions, t)
+		}
+	}
+	return sub
+}
+
+func getPortfolioPerformance(userID, portfolioID string, from, to time.Time, apiKey string) (*PortfolioPerformance, error) {
+	l, lfx, err := loadLedger(userID, portfolioID, from, to, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	perf := &PortfolioPerformance{
+		From:         from.Format(dateLayout),
+		To:           to.Format(dateLayout),
+		PortfolioID:  portfolioID,
+		BaseCurrency: lfx.conv.base,
+		Rates:        lfx.conv.used,
+		Portfolio:    computePerformance(l, from, to),
+		Holdings:     []PerformanceResult{},
+	}
+
+	for _, ticker := range l.tickers() {
+		result := computePerformance(l.filter(ticker), from, to)
+		// Skip tickers that were fully sold before the period began
+		if result.StartValue == 0 && result.EndValue == 0 && result.NetContributions == 0 && result.Dividends == 0 {
+			continue
+		}
+		result.Ticker = ticker
+		perf.Holdings = append(perf.Holdings, result)
+	}
+
+	return perf, nil
+}
+
+func registerPerformanceRoutes(protected *gin.RouterGroup, apiKey string) {
+	protected.GET("/performance", func(c *gin.Context) {
+		from, to, err := parseDateRange(c, 365*24*time.Hour)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ticker, err := normalizeOptionalSymbol(c.Query("ticker"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		perf, err := getPortfolioPerformance(c.GetString("user_id"), c.Query("portfolio_id"), from, to, apiKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if ticker != "" {
+			holdings := []PerformanceResult{}
+			for _, h := range perf.Holdings {
+				if h.Ticker == ticker {
+					holdings = append(holdings, h)
+				}
+			}
+			perf.Holdings = holdings
+		}
+
+		c.JSON(http.StatusOK, perf)
+	})
+}
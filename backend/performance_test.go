@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestXIRR(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse(dateLayout, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		name   string
+		flows  []cashFlow
+		want   float64
+		wantOK bool
+	}{
+		{
+			name:   "one year at ten percent",
+			flows:  []cashFlow{{day("2023-01-01"), -100}, {day("2024-01-01"), 110}},
+			want:   0.1,
+			wantOK: true,
+		},
+		{
+			name:   "loss over two years",
+			flows:  []cashFlow{{day("2022-01-01"), -100}, {day("2024-01-01"), 81}},
+			want:   -0.1,
+			wantOK: true,
+		},
+		{
+			name: "contributions and a dividend",
+			flows: []cashFlow{
+				{day("2023-01-01"), -1000}, {day("2023-07-01"), -500},
+				{day("2023-10-01"), 20}, {day("2024-01-01"), 1600},
+			},
+			want:   0.0967,
+			wantOK: true,
+		},
+		{
+			name:  "all flows on the same date",
+			flows: []cashFlow{{day("2024-03-01"), -100}, {day("2024-03-01"), 100}},
+		},
+		{
+			name:  "same date without netting to zero",
+			flows: []cashFlow{{day("2024-03-01"), -100}, {day("2024-03-01"), 90}},
+		},
+		{
+			name:  "only outflows",
+			flows: []cashFlow{{day("2023-01-01"), -100}, {day("2024-01-01"), -10}},
+		},
+		{
+			name:  "single flow",
+			flows: []cashFlow{{day("2023-01-01"), -100}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := xirr(tt.flows)
+			if ok != tt.wantOK {
+				t.Fatalf("xirr ok = %v, want %v (rate %v)", ok, tt.wantOK, got)
+			}
+			if !ok {
+				if got != 0 {
+					t.Errorf("xirr = %v, want 0 when not ok", got)
+				}
+				return
+			}
+			if math.Abs(got-tt.want) > 5e-4 {
+				t.Errorf("xirr = %.6f, want %.4f", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultPortfolioName is used for the portfolio that is created on demand
+// when a user adds a holding without choosing an account.
+const defaultPortfolioName = "Default"
+
+var errPortfolioNotFound = errors.New("portfolio not found")
+
+type Portfolio struct {
+	ID          string    `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	AccountType string    `json:"account_type" db:"account_type"`
+	Broker      string    `json:"broker" db:"broker"`
+	Currency    string    `json:"currency" db:"currency"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type CreatePortfolioRequest struct {
+	Name        string `json:"name" binding:"required,max=100"`
+	AccountType string `json:"account_type" binding:"omitempty,oneof=taxable ira roth_ira 401k roth_401k hsa other"`
+	Broker      string `json:"broker" binding:"max=100"`
+	Currency    string `json:"currency" binding:"omitempty,len=3"`
+}
+
+type UpdatePortfolioRequest struct {
+	Name        string `json:"name" binding:"required,max=100"`
+	AccountType string `json:"account_type" binding:"required,oneof=taxable ira roth_ira 401k roth_401k hsa other"`
+	Broker      string `json:"broker" binding:"max=100"`
+	Currency    string `json:"currency" binding:"required,len=3"`
+}
+
+// PortfolioTotals aggregates a set of holdings.
+type PortfolioTotals struct {
+	HoldingsCount   int     `json:"holdings_count"`
+	TotalValue      float64 `json:"total_value"`
+	MonthlyDividend float64 `json:"monthly_dividend"`
+	AnnualDividend  float64 `json:"annual_dividend"`
+	DividendYield   float64 `json:"dividend_yield"`
+}
+
+// PortfolioSummary totals are in BaseCurrency, converted at FXRates.
+type PortfolioSummary struct {
+	Portfolio
+	PortfolioTotals
+	BaseCurrency string   `json:"base_currency,omitempty"`
+	FXRates      []FXRate `json:"fx_rates,omitempty"`
+}
+
+// AggregatePosition combines every holding of one ticker across portfolios.
+type AggregatePosition struct {
+	Ticker          string   `json:"ticker"`
+	Company         string   `json:"company"`
+	Shares          int      `json:"shares"`
+	CurrentPrice    float64  `json:"current_price"`
+	TotalValue      float64  `json:"total_value"`
+	MonthlyDividend float64  `json:"monthly_dividend"`
+	DividendYield   float64  `json:"dividend_yield"`
+	PortfolioIDs    []string `json:"portfolio_ids"`
+}
+
+// PortfoliosOverview amounts are in BaseCurrency, converted at FXRates.
+type PortfoliosOverview struct {
+	Portfolios   []PortfolioSummary  `json:"portfolios"`
+	Positions    []AggregatePosition `json:"positions"`
+	Total        PortfolioTotals     `json:"total"`
+	BaseCurrency string              `json:"base_currency"`
+	FXRates      []FXRate            `json:"fx_rates"`
+}
+
+const portfolioColumns = `id, name, account_type, broker, currency, created_at, updated_at`
+
+func scanPortfolio(row rowScanner) (Portfolio, error) {
+	var p Portfolio
+	err := row.Scan(&p.ID, &p.Name, &p.AccountType, &p.Broker, &p.Currency, &p.CreatedAt, &p.UpdatedAt)
+	return p, err
+}
+
+func getPortfolios(userID string) ([]Portfolio, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot load portfolios")
+	}
+
+	rows, err := db.Query(`SELECT `+portfolioColumns+` FROM portfolios WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query portfolios: %v", err)
+	}
+	defer rows.Close()
+
+	var portfolios []Portfolio
+	for rows.Next() {
+		p, err := scanPortfolio(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan portfolio: %v", err)
+		}
+		portfolios = append(portfolios, p)
+	}
+
+	return portfolios, rows.Err()
+}
+
+// getPortfolio loads a single portfolio, returning sql.ErrNoRows when it does
+// not exist or belongs to another user.
+func getPortfolio(id string, userID string) (*Portfolio, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot load portfolios")
+	}
+
+	p, err := scanPortfolio(db.QueryRow(`SELECT `+portfolioColumns+` FROM portfolios WHERE id = $1 AND user_id = $2`, id, userID))
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func createPortfolio(req CreatePortfolioRequest, userID string) (*Portfolio, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot create portfolios")
+	}
+
+	if req.AccountType == "" {
+		req.AccountType = "taxable"
+	}
+	if req.Currency == "" {
+		req.Currency = "USD"
+	}
+
+	query := `
+		INSERT INTO portfolios (name, account_type, broker, currency, user_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+		RETURNING ` + portfolioColumns + `
+	`
+
+	p, err := scanPortfolio(db.QueryRow(query, strings.TrimSpace(req.Name), req.AccountType, req.Broker, strings.ToUpper(req.Currency), userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create portfolio: %v", err)
+	}
+	return &p, nil
+}
+
+func updatePortfolio(id string, req UpdatePortfolioRequest, userID string) (*Portfolio, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot update portfolios")
+	}
+
+	query := `
+		UPDATE portfolios
+		SET name = $1, account_type = $2, broker = $3, currency = $4, updated_at = NOW()
+		WHERE id = $5 AND user_id = $6
+		RETURNING ` + portfolioColumns + `
+	`
+
+	p, err := scanPortfolio(db.QueryRow(query, strings.TrimSpace(req.Name), req.AccountType, req.Broker, strings.ToUpper(req.Currency), id, userID))
+	if err == sql.ErrNoRows {
+		return nil, errPortfolioNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update portfolio: %v", err)
+	}
+	return &p, nil
+}
+
+// deletePortfolio removes a portfolio; its holdings are removed by the
+// ON DELETE CASCADE on portfolio_holdings.portfolio_id.
+func deletePortfolio(id string, userID string) error {
+	if db == nil {
+		return fmt.Errorf("database unavailable - cannot delete portfolios")
+	}
+
+	result, err := db.Exec("DELETE FROM portfolios WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete portfolio: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return errPortfolioNotFound
+	}
+
+	return nil
+}
+
+// getOrCreateDefaultPortfolio returns the user's oldest portfolio, creating
+// one named defaultPortfolioName if the user has none yet. It keeps the
+// original single-list /portfolio endpoints working.
+func getOrCreateDefaultPortfolio(userID string) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("database unavailable - cannot load portfolios")
+	}
+
+	var id string
+	err := db.QueryRow("SELECT id FROM portfolios WHERE user_id = $1 ORDER BY created_at LIMIT 1", userID).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", fmt.Errorf("failed to query portfolios: %v", err)
+	}
+
+	query := `
+		INSERT INTO portfolios (name, account_type, broker, currency, user_id, created_at, updated_at)
+		VALUES ($1, 'taxable', '', 'USD', $2, NOW(), NOW())
+		ON CONFLICT (user_id, name) DO UPDATE SET updated_at = portfolios.updated_at
+		RETURNING id
+	`
+	if err := db.QueryRow(query, defaultPortfolioName, userID).Scan(&id); err != nil {
+		return "", fmt.Errorf("failed to create default portfolio: %v", err)
+	}
+	return id, nil
+}
+
+// resolvePortfolioID validates a requested portfolio id, falling back to the
+// user's default portfolio when none is given.
+func resolvePortfolioID(requested string, userID string) (string, error) {
+	if requested == "" {
+		return getOrCreateDefaultPortfolio(userID)
+	}
+	if _, err := getPortfolio(requested, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return "", errPortfolioNotFound
+		}
+		return "", fmt.Errorf("failed to load portfolio: %v", err)
+	}
+	return requested, nil
+}
+
+// holdingInPortfolio reports whether a holding belongs to the given portfolio.
+func holdingInPortfolio(id string, portfolioID string, userID string) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("database unavailable - cannot load holdings")
+	}
+
+	var exists bool
+	err := db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM portfolio_holdings WHERE id = $1 AND portfolio_id = $2 AND user_id = $3)",
+		id, portfolioID, userID,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up holding: %v", err)
+	}
+	return exists, nil
+}
+
+// addHoldingToPortfolio creates a holding after resolving the ticker and
+// checking it is not already held in the same portfolio. The same ticker may
+// be held in several portfolios.
+func addHoldingToPortfolio(c *gin.Context, req CreateHoldingRequest, portfolioID string, apiKey string) {
+	userID := c.GetString("user_id")
+
+	ticker, ok := validateTicker(c, req.Ticker, apiKey)
+	if !ok {
+		return
+	}
+	req.Ticker = ticker
+
+	holdings, err := getPortfolioHoldings(portfolioID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing holdings"})
+		return
+	}
+
+	for _, holding := range holdings {
+		if holding.Ticker == req.Ticker {
+			c.JSON(http.StatusConflict, gin.H{"error": "Stock already exists in portfolio"})
+			return
+		}
+	}
+
+	holding, err := createHolding(req.Ticker, req.Shares, apiKey, userID, portfolioID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, holding)
+}
+
+func totalHoldings(holdings []PortfolioHolding) PortfolioTotals {
+	var t PortfolioTotals
+	for _, h := range holdings {
+		t.HoldingsCount++
+		t.TotalValue += h.TotalValue
+		t.MonthlyDividend += h.MonthlyDividend
+	}
+	t.AnnualDividend = t.MonthlyDividend * 12
+	if t.TotalValue > 0 {
+		t.DividendYield = roundTo(t.AnnualDividend/t.TotalValue*100, 2)
+	}
+	return t
+}
+
+// aggregatePositions merges holdings of the same ticker held in different
+// portfolios into a single position.
+func aggregatePositions(holdings []PortfolioHolding) []AggregatePosition {
+	byTicker := map[string]*AggregatePosition{}
+	for _, h := range holdings {
+		p, ok := byTicker[h.Ticker]
+		if !ok {
+			p = &AggregatePosition{Ticker: h.Ticker, Company: h.Company, CurrentPrice: h.CurrentPrice}
+			byTicker[h.Ticker] = p
+		}
+		p.Shares += h.Shares
+		p.TotalValue += h.TotalValue
+		p.MonthlyDividend += h.MonthlyDividend
+		p.PortfolioIDs = append(p.PortfolioIDs, h.PortfolioID)
+	}
+
+	positions := make([]AggregatePosition, 0, len(byTicker))
+	for _, p := range byTicker {
+		if p.TotalValue > 0 {
+			p.DividendYield = roundTo(p.MonthlyDividend*12/p.TotalValue*100, 2)
+		}
+		positions = append(positions, *p)
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i].Ticker < positions[j].Ticker })
+	return positions
+}
+
+// getPortfoliosOverview totals every portfolio in base, or in the user's
+// base currency when base is empty.
+func getPortfoliosOverview(userID, base string) (*PortfoliosOverview, error) {
+	portfolios, err := getPortfolios(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	holdings, conv, err := getBaseHoldings(userID, "", base)
+	if err != nil {
+		return nil, err
+	}
+
+	byPortfolio := map[string][]PortfolioHolding{}
+	for _, h := range holdings {
+		byPortfolio[h.PortfolioID] = append(byPortfolio[h.PortfolioID], h)
+	}
+
+	overview := &PortfoliosOverview{
+		Portfolios: make([]PortfolioSummary, 0, len(portfolios)),
+		Positions:  aggregatePositions(holdings),
+		Total:      totalHoldings(holdings),
+
+		BaseCurrency: conv.base,
+		FXRates:      conv.used,
+	}
+	for _, p := range portfolios {
+		overview.Portfolios = append(overview.Portfolios, PortfolioSummary{
+			Portfolio:       p,
+			PortfolioTotals: totalHoldings(byPortfolio[p.ID]),
+		})
+	}
+
+	return overview, nil
+}
+
+// registerPortfolioRoutes wires up the multi-account endpoints under
+// /portfolios. Holdings routes mirror the legacy /portfolio ones but are
+// scoped to a single portfolio.
+func registerPortfolioRoutes(r *gin.Engine, apiKey string) {
+	portfolios := r.Group("/portfolios")
+	portfolios.Use(authMiddleware())
+
+	portfolios.GET("", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		list, err := getPortfolios(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	portfolios.POST("", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		var req CreatePortfolioRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		portfolio, err := createPortfolio(req, userID)
+		if err != nil {
+			if strings.Contains(err.Error(), "duplicate key") {
+				c.JSON(http.StatusConflict, gin.H{"error": "A portfolio with that name already exists"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, portfolio)
+	})
+
+	portfolios.GET("/summary", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		overview, err := getPortfoliosOverview(userID, c.Query("base_currency"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, overview)
+	})
+
+	portfolios.GET("/:pid", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		portfolio, err := getPortfolio(c.Param("pid"), userID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Portfolio not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		holdings, conv, err := getBaseHoldings(userID, portfolio.ID, c.Query("base_currency"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, PortfolioSummary{
+			Portfolio:       *portfolio,
+			PortfolioTotals: totalHoldings(holdings),
+			BaseCurrency:    conv.base,
+			FXRates:         conv.used,
+		})
+	})
+
+	portfolios.PUT("/:pid", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		var req UpdatePortfolioRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		portfolio, err := updatePortfolio(c.Param("pid"), req, userID)
+		if err == errPortfolioNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Portfolio not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, portfolio)
+	})
+
+	portfolios.DELETE("/:pid", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		err := deletePortfolio(c.Param("pid"), userID)
+		if err == errPortfolioNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Portfolio not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Portfolio deleted successfully"})
+	})
+
+	// requirePortfolio aborts with 404 unless :pid belongs to the caller.
+	requirePortfolio := func(c *gin.Context) {
+		_, err := getPortfolio(c.Param("pid"), c.GetString("user_id"))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Portfolio not found"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+
+	// requireHolding aborts with 404 unless :id is a holding in :pid.
+	requireHolding := func(c *gin.Context) {
+		ok, err := holdingInPortfolio(c.Param("id"), c.Param("pid"), c.GetString("user_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Holding not found"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+
+	holdings := portfolios.Group("/:pid/holdings")
+	holdings.Use(requirePortfolio)
+
+	holdings.GET("", func(c *gin.Context) {
+		list, err := getPortfolioHoldings(c.Param("pid"), c.GetString("user_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	holdings.POST("", func(c *gin.Context) {
+		var req CreateHoldingRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		addHoldingToPortfolio(c, req, c.Param("pid"), apiKey)
+	})
+
+	holdings.PUT("/:id", requireHolding, func(c *gin.Context) {
+		var req UpdateHoldingRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		holding, err := updateHolding(c.Param("id"), req.Shares, apiKey, c.GetString("user_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, holding)
+	})
+
+	holdings.DELETE("/:id", requireHolding, func(c *gin.Context) {
+		if err := deleteHolding(c.Param("id"), c.GetString("user_id")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Holding deleted successfully"})
+	})
+}
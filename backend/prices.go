@@ -0,0 +1,264 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PriceBar is one trading day of OHLC data.
+type PriceBar struct {
+	Date     string  `json:"date"`
+	Open     float64 `json:"open"`
+	High     float64 `json:"high"`
+	Low      float64 `json:"low"`
+	Close    float64 `json:"close"`
+	AdjClose float64 `json:"adj_close"`
+	Volume   int64   `json:"volume"`
+}
+
+type PriceHistory struct {
+	Symbol string     `json:"symbol"`
+	From   string     `json:"from"`
+	To     string     `json:"to"`
+	Prices []PriceBar `json:"prices"`
+}
+
+func fetchFMPHistoricalPrices(symbol string, from, to time.Time, apiKey string) ([]PriceBar, error) {
+	url := fmt.Sprintf("https://financialmodelingprep.com/api/v3/historical-price-full/%s?from=%s&to=%s&apikey=%s",
+		symbol, from.Format(dateLayout), to.Format(dateLayout), apiKey)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch price history from FMP: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("FMP price history API returned status %d", resp.StatusCode)
+	}
+
+	var fmpResp struct {
+		Symbol     string `json:"symbol"`
+		Historical []struct {
+			Date     string  `json:"date"`
+			Open     float64 `json:"open"`
+			High     float64 `json:"high"`
+			Low      float64 `json:"low"`
+			Close    float64 `json:"close"`
+			AdjClose float64 `json:"adjClose"`
+			Volume   float64 `json:"volume"`
+		} `json:"historical"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&fmpResp); err != nil {
+		return nil, fmt.Errorf("failed to parse FMP price history response: %v", err)
+	}
+
+	bars := make([]PriceBar, 0, len(fmpResp.Historical))
+	for _, h := range fmpResp.Historical {
+		bars = append(bars, PriceBar{
+			Date:     h.Date,
+			Open:     h.Open,
+			High:     h.High,
+			Low:      h.Low,
+			Close:    h.Close,
+			AdjClose: h.AdjClose,
+			Volume:   int64(h.Volume),
+		})
+	}
+
+	// FMP returns newest first
+	sort.Slice(bars, func(i, j int) bool { return bars[i].Date < bars[j].Date })
+	return bars, nil
+}
+
+// storePriceBars upserts bars and widens the symbol's fetched range to
+// include [from, to]. The range is tracked separately because weekends and
+// holidays have no rows, so gaps in price_history alone do not tell us
+// whether a period was already fetched.
+func storePriceBars(symbol string, bars []PriceBar, from, to time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin price transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO price_history (symbol, price_date, open, high, low, close, adj_close, volume)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (symbol, price_date) DO UPDATE
+		SET open = EXCLUDED.open, high = EXCLUDED.high, low = EXCLUDED.low,
+			close = EXCLUDED.close, adj_close = EXCLUDED.adj_close, volume = EXCLUDED.volume
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare price insert: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, b := range bars {
+		if _, err := stmt.Exec(symbol, b.Date, b.Open, b.High, b.Low, b.Close, b.AdjClose, b.Volume); err != nil {
+			return fmt.Errorf("failed to insert price for %s on %s: %v", symbol, b.Date, err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO price_history_ranges (symbol, from_date, to_date, fetched_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (symbol) DO UPDATE
+		SET from_date = LEAST(price_history_ranges.from_date, EXCLUDED.from_date),
+			to_date = GREATEST(price_history_ranges.to_date, EXCLUDED.to_date),
+			fetched_at = NOW()
+	`, symbol, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to record fetched price range: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// priceRefreshInterval is how long the most recent stored day is trusted
+// before it is fetched again, so a bar captured mid-session is eventually
+// replaced by the final close.
+const priceRefreshInterval = time.Hour
+
+// maxPriceHistoryYears bounds how far back GET /prices/:symbol reaches, so
+// one request cannot pull decades of bars from the provider.
+const maxPriceHistoryYears = 10
+
+// ensurePriceHistory fetches whatever part of [from, to] has not been stored
+// yet. Fetches always extend the stored range contiguously so it never
+// claims to cover a gap that was skipped.
+func ensurePriceHistory(symbol string, from, to time.Time, apiKey string) error {
+	var storedFrom, storedTo, fetchedAt time.Time
+	err := db.QueryRow("SELECT from_date, to_date, fetched_at FROM price_history_ranges WHERE symbol = $1", symbol).Scan(&storedFrom, &storedTo, &fetchedAt)
+	if err == sql.ErrNoRows {
+		bars, err := fetchFMPHistoricalPrices(symbol, from, to, apiKey)
+		if err != nil {
+			return err
+		}
+		return storePriceBars(symbol, bars, from, to)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load fetched price range: %v", err)
+	}
+
+	if from.Before(storedFrom) {
+		end := storedFrom.AddDate(0, 0, -1)
+		bars, err := fetchFMPHistoricalPrices(symbol, from, end, apiKey)
+		if err != nil {
+			return err
+		}
+		if err := storePriceBars(symbol, bars, from, end); err != nil {
+			return err
+		}
+	}
+
+	// A last day fetched before that day was over may hold an intraday bar
+	partial := fetchedAt.Before(storedTo.AddDate(0, 0, 1))
+	stale := to.Equal(storedTo) && partial && time.Since(fetchedAt) > priceRefreshInterval
+	if to.After(storedTo) || stale {
+		bars, err := fetchFMPHistoricalPrices(symbol, storedTo, to, apiKey)
+		if err != nil {
+			return err
+		}
+		if err := storePriceBars(symbol, bars, storedTo, to); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getPriceHistory returns daily bars for symbol between from and to
+// (inclusive), oldest first, fetching any missing range from the provider.
+func getPriceHistory(symbol string, from, to time.Time, apiKey string) ([]PriceBar, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot load price history")
+	}
+
+	// There is nothing to fetch beyond today
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if to.After(today) {
+		to = today
+	}
+	if from.After(to) {
+		return []PriceBar{}, nil
+	}
+
+	if err := ensurePriceHistory(symbol, from, to, apiKey); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT price_date, open, high, low, close, adj_close, volume
+		FROM price_history
+		WHERE symbol = $1 AND price_date BETWEEN $2 AND $3
+		ORDER BY price_date
+	`, symbol, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query price history: %v", err)
+	}
+	defer rows.Close()
+
+	bars := []PriceBar{}
+	for rows.Next() {
+		var b PriceBar
+		var date time.Time
+		if err := rows.Scan(&date, &b.Open, &b.High, &b.Low, &b.Close, &b.AdjClose, &b.Volume); err != nil {
+			return nil, fmt.Errorf("failed to scan price: %v", err)
+		}
+		b.Date = date.Format(dateLayout)
+		bars = append(bars, b)
+	}
+
+	return bars, rows.Err()
+}
+
+func registerPriceRoutes(r *gin.Engine, apiKey string) {
+	r.GET("/prices/:symbol", func(c *gin.Context) {
+		symbol, err := normalizeSymbol(c.Param("symbol"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		from, to, err := parseDateRange(c, 365*24*time.Hour)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// Clamp to today first so a future to neither drags the range
+		// limit forward nor leaves the default range empty
+		now := time.Now().UTC()
+		if today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC); to.After(today) {
+			to = today
+			if c.Query("from") == "" {
+				from = to.Add(-365 * 24 * time.Hour)
+			}
+		}
+		if earliest := to.AddDate(-maxPriceHistoryYears, 0, 0); from.Before(earliest) {
+			from = earliest
+		}
+
+		bars, err := getPriceHistory(symbol, from, to, apiKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, PriceHistory{
+			Symbol: symbol,
+			From:   from.Format(dateLayout),
+			To:     to.Format(dateLayout),
+			Prices: bars,
+		})
+	})
+}
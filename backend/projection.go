@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cagrYears is the look-back used for a holding's historical dividend
+// growth. Shorter windows are tried when the history is not that long.
+const cagrYears = 5
+
+// ProjectionRequest describes a projection. Growth rates are annual
+// percentages. A null DividendGrowth uses each holding's historical
+// dividend CAGR instead of one rate for all.
+//
+// In monte_carlo mode the rates are sampled from history instead (see
+// runMonteCarlo) and the assumptions only apply to holdings without
+// history. Runs defaults to defaultMonteCarloRuns; a null Seed picks one,
+// which is returned so the run can be reproduced.
+//
+// Inflation deflates the real figures to today's dollars; null uses the
+// trailing CPI trend.
+type ProjectionRequest struct {
+	PortfolioID         string   `json:"portfolio_id"`
+	Years               int      `json:"years" binding:"required,min=1,max=60"`
+	MonthlyContribution float64  `json:"monthly_contribution" binding:"min=0"`
+	Drip                bool     `json:"drip"`
+	DividendGrowth      *float64 `json:"dividend_growth" binding:"omitempty,min=-100"`
+	PriceGrowth         float64  `json:"price_growth" binding:"min=-100"`
+	Mode                string   `json:"mode" binding:"omitempty,oneof=deterministic monte_carlo"`
+	Runs                int      `json:"runs" binding:"omitempty,min=1,max=10000"`
+	Seed                *int64   `json:"seed"`
+	Inflation           *float64 `json:"inflation" binding:"omitempty,min=-50"`
+}
+
+// ProjectionAssumption is the dividend growth used for one holding and
+// where it came from: "assumed", "historical" or "none" when there was no
+// usable history.
+type ProjectionAssumption struct {
+	Ticker         string  `json:"ticker"`
+	DividendGrowth float64 `json:"dividend_growth"`
+	Source         string  `json:"source"`
+}
+
+// ProjectionYear is the state at the end of a projected year; year 0 is
+// today. AnnualIncome is gross forward income at that point, of which
+// AnnualWithheld is withheld at source and NetAnnualIncome received.
+// DividendsReceived is what was paid gross during the year and
+// DividendsWithheld the part withheld; Cash holds net dividends not
+// reinvested. Real figures are gross, in today's dollars.
+type ProjectionYear struct {
+	Year                int     `json:"year"`
+	Contributions       float64 `json:"contributions"`
+	Value               float64 `json:"value"`
+	Cash                float64 `json:"cash"`
+	DividendsReceived   float64 `json:"dividends_received"`
+	DividendsWithheld   float64 `json:"dividends_withheld"`
+	CumulativeDividends float64 `json:"cumulative_dividends"`
+	AnnualIncome        float64 `json:"annual_income"`
+	MonthlyIncome       float64 `json:"monthly_income"`
+	AnnualWithheld      float64 `json:"annual_withheld"`
+	NetAnnualIncome     float64 `json:"net_annual_income"`
+	NetMonthlyIncome    float64 `json:"net_monthly_income"`
+	DividendYield       float64 `json:"dividend_yield"`
+	RealValue           float64 `json:"real_value"`
+	RealAnnualIncome    float64 `json:"real_annual_income"`
+	RealMonthlyIncome   float64 `json:"real_monthly_income"`
+}
+
+type ProjectionResult struct {
+	ProjectionRequest
+	Holdings []ProjectionAssumption `json:"holdings"`
+	Series   []ProjectionYear       `json:"series"`
+	Warnings []string               `json:"warnings"`
+}
+
+// projectionHolding is a position as it evolves through a projection.
+// Shares are fractional once contributions and reinvestment start.
+// WithholdingRate is the percent of its dividends withheld at source.
+type projectionHolding struct {
+	Ticker          string
+	Shares          float64
+	Price           float64
+	AnnualDividend  float64
+	DividendGrowth  float64
+	WithholdingRate float64
+}
+
+// projectionRates returns the price and dividend growth, in percent, of
+// holding h during year y (1-based).
+type projectionRates func(y, h int) (price, dividend float64)
+
+// projectPath runs a projection month by month. Contributions are split
+// across holdings by current value, dividends are paid monthly at a
+// twelfth of the annual rate, net of withholding, and dividend rates step
+// up once a year.
+func projectPath(start []projectionHolding, req ProjectionRequest, rates projectionRates) []ProjectionYear {
+	holdings := make([]projectionHolding, len(start))
+	copy(holdings, start)
+
+	var contributions, cash, cumulative float64
+	point := func(year int, received, withheld float64) ProjectionYear {
+		p := ProjectionYear{Year: year}
+		for _, h := range holdings {
+			p.Value += h.Shares * h.Price
+			p.AnnualIncome += h.Shares * h.AnnualDividend
+			p.AnnualWithheld += h.Shares * h.AnnualDividend * h.WithholdingRate / 100
+		}
+		if p.Value > 0 {
+			p.DividendYield = roundTo(p.AnnualIncome/p.Value*100, 2)
+		}
+		deflator := 1.0
+		if req.Inflation != nil {
+			deflator = math.Pow(1+*req.Inflation/100, float64(year))
+		}
+		p.RealValue = roundTo(p.Value/deflator, 2)
+		p.RealAnnualIncome = roundTo(p.AnnualIncome/deflator, 2)
+		p.RealMonthlyIncome = roundTo(p.AnnualIncome/deflator/12, 2)
+		p.Contributions = roundTo(contributions, 2)
+		p.Value = roundTo(p.Value, 2)
+		p.Cash = roundTo(cash, 2)
+		p.DividendsReceived = roundTo(received, 2)
+		p.DividendsWithheld = roundTo(withheld, 2)
+		p.CumulativeDividends = roundTo(cumulative, 2)
+		net := p.AnnualIncome - p.AnnualWithheld
+		p.NetAnnualIncome = roundTo(net, 2)
+		p.NetMonthlyIncome = roundTo(net/12, 2)
+		p.AnnualWithheld = roundTo(p.AnnualWithheld, 2)
+		p.MonthlyIncome = roundTo(p.AnnualIncome/12, 2)
+		p.AnnualIncome = roundTo(p.AnnualIncome, 2)
+		return p
+	}
+
+	series := []ProjectionYear{point(0, 0, 0)}
+	priceFactors := make([]float64, len(holdings))
+	for y := 1; y <= req.Years; y++ {
+		dividendGrowth := make([]float64, len(holdings))
+		for i := range holdings {
+			price, dividend := rates(y, i)
+			priceFactors[i] = math.Pow(1+price/100, 1.0/12)
+			dividendGrowth[i] = dividend
+		}
+
+		var received, withheld float64
+		for m := 0; m < 12; m++ {
+			var value float64
+			for i := range holdings {
+				holdings[i].Price *= priceFactors[i]
+				value += holdings[i].Shares * holdings[i].Price
+			}
+
+			for i := range holdings {
+				h := &holdings[i]
+				if h.Price <= 0 {
+					continue
+				}
+				weight := 1 / float64(len(holdings))
+				if value > 0 {
+					weight = h.Shares * h.Price / value
+				}
+				h.Shares += req.MonthlyContribution * weight / h.Price
+
+				dividend := h.Shares * h.AnnualDividend / 12
+				tax := dividend * h.WithholdingRate / 100
+				received += dividend
+				withheld += tax
+				if req.Drip {
+					h.Shares += (dividend - tax) / h.Price
+				} else {
+					cash += dividend - tax
+				}
+			}
+			contributions += req.MonthlyContribution
+		}
+		cumulative += received
+
+		for i := range holdings {
+			holdings[i].AnnualDividend *= 1 + dividendGrowth[i]/100
+		}
+		series = append(series, point(y, received, withheld))
+	}
+	return series
+}
+
+// historicalDividendGrowth returns symbol's dividend CAGR over the last
+// cagrYears complete calendar years, or the longest shorter window that
+// has data.
+func historicalDividendGrowth(symbol, apiKey string) (float64, bool, error) {
+	events, err := getDividendHistory(symbol, apiKey)
+	if err != nil {
+		return 0, false, err
+	}
+	lastYear := time.Now().Year() - 1
+	for years := cagrYears; years >= 1; years-- {
+		if cagr, ok := dividendCAGR(events, lastYear, years); ok {
+			return cagr, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// loadProjectionHoldings starts a projection from the user's stored
+// holdings and resolves each one's dividend growth assumption and
+// withholding rate.
+func loadProjectionHoldings(userID string, req ProjectionRequest, apiKey string) ([]projectionHolding, []ProjectionAssumption, []string, error) {
+	stored, err := getScopedHoldings(userID, req.PortfolioID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	withholding, err := tickerWithholding(userID, stored)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	positions := rebalancePositions(stored)
+
+	var holdings []projectionHolding
+	assumptions := []ProjectionAssumption{}
+	warnings := []string{}
+	for _, p := range positions {
+		if p.Shares <= 0 || p.Price <= 0 {
+			continue
+		}
+		h := projectionHolding{
+			Ticker: p.Ticker, Shares: float64(p.Shares), Price: p.Price,
+			AnnualDividend: p.AnnualDividend, WithholdingRate: withholding[p.Ticker],
+		}
+		a := ProjectionAssumption{Ticker: p.Ticker, Source: "assumed"}
+
+		if req.DividendGrowth != nil {
+			h.DividendGrowth = *req.DividendGrowth
+		} else if cagr, ok, err := historicalDividendGrowth(p.Ticker, apiKey); err != nil {
+			a.Source = "none"
+			warnings = append(warnings, fmt.Sprintf("no dividend history for %s, assuming no growth: %v", p.Ticker, err))
+		} else if !ok {
+			a.Source = "none"
+			warnings = append(warnings, fmt.Sprintf("not enough dividend history for %s, assuming no growth", p.Ticker))
+		} else {
+			h.DividendGrowth = cagr
+			a.Source = "historical"
+		}
+
+		a.DividendGrowth = roundTo(h.DividendGrowth, 2)
+		holdings = append(holdings, h)
+		assumptions = append(assumptions, a)
+	}
+	return holdings, assumptions, warnings, nil
+}
+
+func registerProjectionRoutes(protected *gin.RouterGroup, apiKey string) {
+	protected.POST("/projection", func(c *gin.Context) {
+		var req ProjectionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		holdings, assumptions, warnings, err := loadProjectionHoldings(c.GetString("user_id"), req, apiKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(holdings) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no priced holdings to project from"})
+			return
+		}
+
+		if req.Inflation == nil {
+			if cpi, err := loadCPI(); err != nil {
+				warnings = append(warnings, fmt.Sprintf("no CPI data, real figures are not adjusted: %v", err))
+			} else {
+				trend := roundTo(cpi.trend, 2)
+				req.Inflation = &trend
+			}
+		}
+
+		if req.Mode == "monte_carlo" {
+			if req.Runs == 0 {
+				req.Runs = defaultMonteCarloRuns
+			}
+			seed := time.Now().UnixNano()
+			if req.Seed != nil {
+				seed = *req.Seed
+			}
+
+			now := time.Now()
+			histories := make([]holdingHistory, len(holdings))
+			for i, h := range holdings {
+				var w []string
+				histories[i], w = loadHoldingHistory(h.Ticker, now, apiKey)
+				warnings = append(warnings, w...)
+			}
+
+			bands, completed, truncated := runMonteCarlo(holdings, histories, req, req.Runs, seed)
+			c.JSON(http.StatusOK, MonteCarloResult{
+				ProjectionRequest: req,
+				Seed:              seed,
+				RunsCompleted:     completed,
+				Truncated:         truncated,
+				Holdings:          assumptions,
+				Bands:             bands,
+				Warnings:          warnings,
+			})
+			return
+		}
+
+		series := projectPath(holdings, req, func(y, h int) (float64, float64) {
+			return req.PriceGrowth, holdings[h].DividendGrowth
+		})
+
+		c.JSON(http.StatusOK, ProjectionResult{
+			ProjectionRequest: req,
+			Holdings:          assumptions,
+			Series:            series,
+			Warnings:          warnings,
+		})
+	})
+}
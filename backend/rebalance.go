@@ -0,0 +1,564 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Target types: a target weight applies to a single ticker or to every
+// holding in a sector.
+const (
+	TargetHolding = "holding"
+	TargetSector  = "sector"
+)
+
+// defaultTargetTolerance is the drift band, in percentage points, used when
+// a target does not set its own.
+const defaultTargetTolerance = 5.0
+
+// AllocationTarget is a desired weight, as a percentage of total value.
+// A zero Tolerance means defaultTargetTolerance. Holdings without a target
+// are left alone when rebalancing.
+type AllocationTarget struct {
+	Type      string  `json:"type" binding:"required,oneof=holding sector"`
+	Key       string  `json:"key" binding:"required"`
+	Weight    float64 `json:"weight" binding:"min=0,max=100"`
+	Tolerance float64 `json:"tolerance" binding:"min=0,max=100"`
+}
+
+type AllocationTargetsRequest struct {
+	Targets []AllocationTarget `json:"targets" binding:"dive"`
+}
+
+// TargetDrift compares a target with the current weight. Drift is current
+// minus target in percentage points; positive means overweight.
+type TargetDrift struct {
+	Type          string  `json:"type"`
+	Key           string  `json:"key"`
+	TargetWeight  float64 `json:"target_weight"`
+	Tolerance     float64 `json:"tolerance"`
+	Value         float64 `json:"value"`
+	CurrentWeight float64 `json:"current_weight"`
+	Drift         float64 `json:"drift"`
+	WithinBand    bool    `json:"within_band"`
+}
+
+// RebalanceTrade is one suggested order. AnnualIncomeChange is the effect of
+// the trade on projected annual dividends at the current dividend rate.
+type RebalanceTrade struct {
+	Ticker             string  `json:"ticker"`
+	Group              string  `json:"group"`
+	Action             string  `json:"action"`
+	Shares             int     `json:"shares"`
+	Price              float64 `json:"price"`
+	Amount             float64 `json:"amount"`
+	AnnualIncomeChange float64 `json:"annual_income_change"`
+}
+
+type RebalancePlan struct {
+	By                 string           `json:"by"`
+	Mode               string           `json:"mode"`
+	PortfolioID        string           `json:"portfolio_id,omitempty"`
+	Cash               float64          `json:"cash"`
+	TotalValue         float64          `json:"total_value"`
+	DriftBefore        []TargetDrift    `json:"drift_before"`
+	Trades             []RebalanceTrade `json:"trades"`
+	CashRemaining      float64          `json:"cash_remaining"`
+	AnnualIncomeBefore float64          `json:"annual_income_before"`
+	AnnualIncomeAfter  float64          `json:"annual_income_after"`
+	AnnualIncomeChange float64          `json:"annual_income_change"`
+	DriftAfter         []TargetDrift    `json:"drift_after"`
+	BaseCurrency       string           `json:"base_currency"`
+	Rates              []FXRate         `json:"rates"`
+	Warnings           []string         `json:"warnings"`
+}
+
+// rebalancePosition is a ticker combined across the scoped portfolios.
+// AnnualDividend is per share.
+type rebalancePosition struct {
+	Ticker         string
+	Sector         string
+	Shares         int
+	Price          float64
+	AnnualDividend float64
+}
+
+func (p *rebalancePosition) value() float64 {
+	return float64(p.Shares) * p.Price
+}
+
+// group returns the key p is matched against targets of type by.
+func (p *rebalancePosition) group(by string) string {
+	if by == TargetHolding {
+		return p.Ticker
+	}
+	if p.Sector == "" {
+		return unknownAllocationKey
+	}
+	return p.Sector
+}
+
+// normalizeTargets normalizes tickers and checks that keys are unique and
+// weights of each type add up to at most 100%.
+func normalizeTargets(targets []AllocationTarget) error {
+	seen := map[string]bool{}
+	sums := map[string]float64{}
+	for i := range targets {
+		t := &targets[i]
+		t.Key = strings.TrimSpace(t.Key)
+		if t.Type == TargetHolding {
+			key, err := normalizeSymbol(t.Key)
+			if err != nil {
+				return err
+			}
+			t.Key = key
+		}
+		id := t.Type + ":" + strings.ToLower(t.Key)
+		if seen[id] {
+			return fmt.Errorf("duplicate %s target for %s", t.Type, t.Key)
+		}
+		seen[id] = true
+		sums[t.Type] += t.Weight
+	}
+	for typ, sum := range sums {
+		if sum > 100.0001 {
+			return fmt.Errorf("%s target weights add up to %.2f%%, more than 100%%", typ, sum)
+		}
+	}
+	return nil
+}
+
+func getAllocationTargets(userID string) ([]AllocationTarget, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot load allocation targets")
+	}
+
+	rows, err := db.Query(`
+		SELECT target_type, target_key, weight, tolerance
+		FROM allocation_targets
+		WHERE user_id = $1
+		ORDER BY target_type, weight DESC, target_key
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query allocation targets: %v", err)
+	}
+	defer rows.Close()
+
+	targets := []AllocationTarget{}
+	for rows.Next() {
+		var t AllocationTarget
+		if err := rows.Scan(&t.Type, &t.Key, &t.Weight, &t.Tolerance); err != nil {
+			return nil, fmt.Errorf("failed to scan allocation target: %v", err)
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
+// replaceAllocationTargets swaps the user's targets for the given set.
+func replaceAllocationTargets(userID string, targets []AllocationTarget) error {
+	if db == nil {
+		return fmt.Errorf("database unavailable - cannot save allocation targets")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin targets transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM allocation_targets WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to clear allocation targets: %v", err)
+	}
+	for _, t := range targets {
+		_, err := tx.Exec(`
+			INSERT INTO allocation_targets (user_id, target_type, target_key, weight, tolerance, updated_at)
+			VALUES ($1, $2, $3, $4, $5, NOW())
+		`, userID, t.Type, t.Key, t.Weight, t.Tolerance)
+		if err != nil {
+			return fmt.Errorf("failed to insert %s target %s: %v", t.Type, t.Key, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// loadRebalancePositions combines the scoped holdings by ticker, in the
+// user's base currency.
+func loadRebalancePositions(userID, portfolioID string) ([]*rebalancePosition, *fxConversion, error) {
+	holdings, conv, err := getBaseHoldings(userID, portfolioID, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return rebalancePositions(holdings), conv, nil
+}
+
+// quotePosition prices a ticker the user does not hold, converting its
+// listing currency into the base currency.
+func quotePosition(ticker, apiKey string, conv *fxConversion) (*rebalancePosition, error) {
+	summary, err := getDividendSummary(ticker, apiKey, 1)
+	if err != nil {
+		return nil, err
+	}
+	price, err := conv.convert(summary.CurrentPrice, summary.Currency)
+	if err != nil {
+		return nil, err
+	}
+	dividend, err := conv.convert(summary.MonthlyDividend*12, summary.Currency)
+	if err != nil {
+		return nil, err
+	}
+	return &rebalancePosition{Ticker: ticker, Sector: summary.Sector, Price: price, AnnualDividend: dividend}, nil
+}
+
+// rebalancePositions combines holdings by ticker.
+func rebalancePositions(holdings []PortfolioHolding) []*rebalancePosition {
+	sectors := map[string]string{}
+	for _, h := range holdings {
+		if sectors[h.Ticker] == "" {
+			sectors[h.Ticker] = h.Sector
+		}
+	}
+
+	var positions []*rebalancePosition
+	for _, a := range aggregatePositions(holdings) {
+		p := &rebalancePosition{Ticker: a.Ticker, Sector: sectors[a.Ticker], Shares: a.Shares, Price: a.CurrentPrice}
+		if a.Shares > 0 {
+			p.AnnualDividend = a.MonthlyDividend * 12 / float64(a.Shares)
+		}
+		positions = append(positions, p)
+	}
+	return positions
+}
+
+// computeDrift measures each target against the positions' current value.
+func computeDrift(targets []AllocationTarget, positions []*rebalancePosition) []TargetDrift {
+	var total float64
+	values := map[string]float64{}
+	for _, p := range positions {
+		total += p.value()
+		values[TargetHolding+":"+p.group(TargetHolding)] += p.value()
+		values[TargetSector+":"+strings.ToLower(p.group(TargetSector))] += p.value()
+	}
+
+	drift := []TargetDrift{}
+	for _, t := range targets {
+		key := t.Key
+		if t.Type == TargetSector {
+			key = strings.ToLower(key)
+		}
+		d := TargetDrift{Type: t.Type, Key: t.Key, TargetWeight: t.Weight, Tolerance: t.Tolerance, Value: roundTo(values[t.Type+":"+key], 2)}
+		if total > 0 {
+			d.CurrentWeight = values[t.Type+":"+key] / total * 100
+		}
+		d.Drift = roundTo(d.CurrentWeight-t.Weight, 2)
+		d.WithinBand = math.Abs(d.CurrentWeight-t.Weight) <= t.Tolerance
+		d.CurrentWeight = roundTo(d.CurrentWeight, 2)
+		drift = append(drift, d)
+	}
+	return drift
+}
+
+// planRebalance suggests whole-share trades towards the targets of type by.
+//
+// In buy_only mode the new cash is spread over underweight groups in
+// proportion to how far each is below target, measured against the value
+// after the cash is added. In buy_sell mode every group outside its band is
+// traded back to target; sells fund buys together with the new cash, and
+// buys are scaled down if they would spend more than that. A sector's trade
+// is split across the holdings already in it in proportion to their value.
+func planRebalance(positions []*rebalancePosition, targets []AllocationTarget, by, mode string, cash float64) *RebalancePlan {
+	plan := &RebalancePlan{By: by, Mode: mode, Cash: cash, Trades: []RebalanceTrade{}, Warnings: []string{}}
+
+	var byTargets []AllocationTarget
+	for _, t := range targets {
+		if t.Type == by {
+			byTargets = append(byTargets, t)
+		}
+	}
+
+	var total float64
+	for _, p := range positions {
+		total += p.value()
+		plan.AnnualIncomeBefore += float64(p.Shares) * p.AnnualDividend
+	}
+	plan.TotalValue = roundTo(total, 2)
+	plan.DriftBefore = computeDrift(byTargets, positions)
+
+	members := func(t AllocationTarget) []*rebalancePosition {
+		var ms []*rebalancePosition
+		for _, p := range positions {
+			if strings.EqualFold(p.group(by), t.Key) && p.Price > 0 {
+				ms = append(ms, p)
+			}
+		}
+		return ms
+	}
+
+	// Amount to trade per target: positive buys, negative sells
+	after := total + cash
+	amounts := make([]float64, len(byTargets))
+	for i, t := range byTargets {
+		var current float64
+		for _, p := range members(t) {
+			current += p.value()
+		}
+		gap := t.Weight/100*after - current
+		switch mode {
+		case "buy_only":
+			if gap > 0 {
+				amounts[i] = gap
+			}
+		default:
+			if after > 0 && math.Abs(current/after*100-t.Weight) > t.Tolerance {
+				amounts[i] = gap
+			}
+		}
+	}
+
+	trade := func(p *rebalancePosition, group string, shares int) {
+		if shares == 0 {
+			return
+		}
+		action := "buy"
+		if shares < 0 {
+			action = "sell"
+		}
+		n := shares
+		if n < 0 {
+			n = -n
+		}
+		p.Shares += shares
+		plan.Trades = append(plan.Trades, RebalanceTrade{
+			Ticker:             p.Ticker,
+			Group:              group,
+			Action:             action,
+			Shares:             n,
+			Price:              p.Price,
+			Amount:             roundTo(float64(n)*p.Price, 2),
+			AnnualIncomeChange: roundTo(float64(shares)*p.AnnualDividend, 2),
+		})
+	}
+
+	// split divides amount across a target's holdings by value, or evenly
+	// when none has value yet
+	split := func(t AllocationTarget, amount float64) map[*rebalancePosition]float64 {
+		ms := members(t)
+		if len(ms) == 0 {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("no priced holdings in %s %s to trade", t.Type, t.Key))
+			return nil
+		}
+		var value float64
+		for _, p := range ms {
+			value += p.value()
+		}
+		parts := map[*rebalancePosition]float64{}
+		for _, p := range ms {
+			if value > 0 {
+				parts[p] = amount * p.value() / value
+			} else {
+				parts[p] = amount / float64(len(ms))
+			}
+		}
+		return parts
+	}
+
+	available := cash
+	for i, t := range byTargets {
+		if amounts[i] >= 0 {
+			continue
+		}
+		for p, amount := range split(t, amounts[i]) {
+			n := int(math.Round(-amount / p.Price))
+			if n > p.Shares {
+				n = p.Shares
+			}
+			available += float64(n) * p.Price
+			trade(p, t.Key, -n)
+		}
+	}
+
+	var wanted float64
+	for _, a := range amounts {
+		if a > 0 {
+			wanted += a
+		}
+	}
+	scale := 1.0
+	if wanted > available && wanted > 0 {
+		scale = available / wanted
+	}
+	for i, t := range byTargets {
+		if amounts[i] <= 0 {
+			continue
+		}
+		for p, amount := range split(t, amounts[i]*scale) {
+			n := int(math.Floor(amount / p.Price))
+			available -= float64(n) * p.Price
+			trade(p, t.Key, n)
+		}
+	}
+
+	sort.SliceStable(plan.Trades, func(i, j int) bool {
+		if plan.Trades[i].Action != plan.Trades[j].Action {
+			return plan.Trades[i].Action == "sell"
+		}
+		return plan.Trades[i].Ticker < plan.Trades[j].Ticker
+	})
+
+	for _, p := range positions {
+		plan.AnnualIncomeAfter += float64(p.Shares) * p.AnnualDividend
+	}
+	plan.CashRemaining = roundTo(available, 2)
+	plan.AnnualIncomeBefore = roundTo(plan.AnnualIncomeBefore, 2)
+	plan.AnnualIncomeAfter = roundTo(plan.AnnualIncomeAfter, 2)
+	plan.AnnualIncomeChange = roundTo(plan.AnnualIncomeAfter-plan.AnnualIncomeBefore, 2)
+	plan.DriftAfter = computeDrift(byTargets, positions)
+	return plan
+}
+
+// addTargetedTickers prices holding targets the user does not own yet so
+// they can be bought.
+func addTargetedTickers(positions []*rebalancePosition, targets []AllocationTarget, apiKey string, conv *fxConversion) ([]*rebalancePosition, []string) {
+	held := map[string]bool{}
+	for _, p := range positions {
+		held[p.Ticker] = true
+	}
+
+	var warnings []string
+	for _, t := range targets {
+		if t.Type != TargetHolding || held[t.Key] || t.Weight == 0 {
+			continue
+		}
+		p, err := quotePosition(t.Key, apiKey, conv)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("could not price %s: %v", t.Key, err))
+			continue
+		}
+		positions = append(positions, p)
+	}
+	return positions, warnings
+}
+
+func registerRebalanceRoutes(protected *gin.RouterGroup, apiKey string) {
+	protected.GET("/targets", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+
+		targets, err := getAllocationTargets(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		positions, _, err := loadRebalancePositions(userID, c.Query("portfolio_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, computeDrift(targets, positions))
+	})
+
+	protected.PUT("/targets", func(c *gin.Context) {
+		var req AllocationTargetsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for i := range req.Targets {
+			if req.Targets[i].Tolerance == 0 {
+				req.Targets[i].Tolerance = defaultTargetTolerance
+			}
+		}
+		if err := normalizeTargets(req.Targets); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userID := c.GetString("user_id")
+		if err := replaceAllocationTargets(userID, req.Targets); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		positions, _, err := loadRebalancePositions(userID, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, computeDrift(req.Targets, positions))
+	})
+
+	protected.GET("/rebalance", func(c *gin.Context) {
+		mode := c.DefaultQuery("mode", "buy_only")
+		if mode != "buy_only" && mode != "buy_sell" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be buy_only or buy_sell"})
+			return
+		}
+
+		var cash float64
+		if s := c.Query("cash"); s != "" {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil || v < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "cash must be a non-negative number"})
+				return
+			}
+			cash = v
+		}
+
+		userID := c.GetString("user_id")
+		targets, err := getAllocationTargets(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Default to holding targets when the user has any
+		by := c.Query("by")
+		if by == "" {
+			by = TargetSector
+			for _, t := range targets {
+				if t.Type == TargetHolding {
+					by = TargetHolding
+					break
+				}
+			}
+		}
+		if by != TargetHolding && by != TargetSector {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "by must be holding or sector"})
+			return
+		}
+		hasTargets := false
+		for _, t := range targets {
+			hasTargets = hasTargets || t.Type == by
+		}
+		if !hasTargets {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no " + by + " targets set; use PUT /portfolio/targets first"})
+			return
+		}
+
+		portfolioID := c.Query("portfolio_id")
+		positions, conv, err := loadRebalancePositions(userID, portfolioID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		var warnings []string
+		if by == TargetHolding {
+			positions, warnings = addTargetedTickers(positions, targets, apiKey, conv)
+		}
+
+		plan := planRebalance(positions, targets, by, mode, cash)
+		plan.PortfolioID = portfolioID
+		plan.BaseCurrency = conv.base
+		plan.Rates = conv.used
+		plan.Warnings = append(plan.Warnings, warnings...)
+		c.JSON(http.StatusOK, plan)
+	})
+}
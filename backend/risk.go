@@ -0,0 +1,239 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RiskSettings are the user's concentration limits. Weights are percentages
+// of total value or of projected annual income; MaxHerfindahl is on the 0-1
+// scale (1 means a single holding).
+type RiskSettings struct {
+	TopN                    int     `json:"top_n" binding:"min=1,max=50"`
+	MaxPositionWeight       float64 `json:"max_position_weight" binding:"gt=0,lte=100"`
+	MaxPositionIncomeWeight float64 `json:"max_position_income_weight" binding:"gt=0,lte=100"`
+	MaxSectorWeight         float64 `json:"max_sector_weight" binding:"gt=0,lte=100"`
+	MaxSectorIncomeWeight   float64 `json:"max_sector_income_weight" binding:"gt=0,lte=100"`
+	MaxHerfindahl           float64 `json:"max_herfindahl" binding:"gt=0,lte=1"`
+}
+
+// defaultRiskSettings apply until the user saves their own.
+var defaultRiskSettings = RiskSettings{
+	TopN:                    5,
+	MaxPositionWeight:       10,
+	MaxPositionIncomeWeight: 15,
+	MaxSectorWeight:         30,
+	MaxSectorIncomeWeight:   40,
+	MaxHerfindahl:           0.15,
+}
+
+type WeightedName struct {
+	Key    string  `json:"key"`
+	Amount float64 `json:"amount"`
+	Weight float64 `json:"weight"`
+}
+
+// Concentration describes how a total (value or income) is spread across
+// holdings. EffectiveHoldings is 1/Herfindahl: the number of equally sized
+// holdings that would give the same concentration.
+type Concentration struct {
+	Total             float64        `json:"total"`
+	Top               []WeightedName `json:"top"`
+	TopNWeight        float64        `json:"top_n_weight"`
+	Herfindahl        float64        `json:"herfindahl"`
+	EffectiveHoldings float64        `json:"effective_holdings"`
+}
+
+// RiskBreach is one limit that the portfolio currently exceeds.
+type RiskBreach struct {
+	Rule    string  `json:"rule"`
+	Subject string  `json:"subject"`
+	Value   float64 `json:"value"`
+	Limit   float64 `json:"limit"`
+}
+
+type RiskReport struct {
+	PortfolioID string             `json:"portfolio_id,omitempty"`
+	Settings    RiskSettings       `json:"settings"`
+	ByValue     Concentration      `json:"by_value"`
+	ByIncome    Concentration      `json:"by_income"`
+	Sectors     []AllocationBucket `json:"sectors"`
+	Breaches    []RiskBreach       `json:"breaches"`
+}
+
+func getRiskSettings(userID string) (RiskSettings, error) {
+	if db == nil {
+		return RiskSettings{}, fmt.Errorf("database unavailable - cannot load risk settings")
+	}
+
+	s := defaultRiskSettings
+	err := db.QueryRow(`
+		SELECT top_n, max_position_weight, max_position_income_weight, max_sector_weight, max_sector_income_weight, max_herfindahl
+		FROM risk_settings WHERE user_id = $1
+	`, userID).Scan(&s.TopN, &s.MaxPositionWeight, &s.MaxPositionIncomeWeight, &s.MaxSectorWeight, &s.MaxSectorIncomeWeight, &s.MaxHerfindahl)
+	if err == sql.ErrNoRows {
+		return defaultRiskSettings, nil
+	}
+	if err != nil {
+		return RiskSettings{}, fmt.Errorf("failed to load risk settings: %v", err)
+	}
+	return s, nil
+}
+
+func saveRiskSettings(userID string, s RiskSettings) error {
+	if db == nil {
+		return fmt.Errorf("database unavailable - cannot save risk settings")
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO risk_settings (user_id, top_n, max_position_weight, max_position_income_weight, max_sector_weight, max_sector_income_weight, max_herfindahl, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET top_n = EXCLUDED.top_n, max_position_weight = EXCLUDED.max_position_weight,
+			max_position_income_weight = EXCLUDED.max_position_income_weight, max_sector_weight = EXCLUDED.max_sector_weight,
+			max_sector_income_weight = EXCLUDED.max_sector_income_weight, max_herfindahl = EXCLUDED.max_herfindahl, updated_at = NOW()
+	`, userID, s.TopN, s.MaxPositionWeight, s.MaxPositionIncomeWeight, s.MaxSectorWeight, s.MaxSectorIncomeWeight, s.MaxHerfindahl)
+	if err != nil {
+		return fmt.Errorf("failed to save risk settings: %v", err)
+	}
+	return nil
+}
+
+// concentration ranks amounts by key and measures how concentrated they are.
+func concentration(amounts map[string]float64, topN int) Concentration {
+	c := Concentration{Top: []WeightedName{}}
+	for _, a := range amounts {
+		c.Total += a
+	}
+
+	names := make([]WeightedName, 0, len(amounts))
+	for key, a := range amounts {
+		w := 0.0
+		if c.Total > 0 {
+			w = a / c.Total
+		}
+		c.Herfindahl += w * w
+		names = append(names, WeightedName{Key: key, Amount: roundTo(a, 2), Weight: roundTo(w*100, 2)})
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Amount != names[j].Amount {
+			return names[i].Amount > names[j].Amount
+		}
+		return names[i].Key < names[j].Key
+	})
+
+	for i, n := range names {
+		if i >= topN {
+			break
+		}
+		c.Top = append(c.Top, n)
+		c.TopNWeight += n.Weight
+	}
+
+	if c.Herfindahl > 0 {
+		c.EffectiveHoldings = roundTo(1/c.Herfindahl, 2)
+	}
+	c.Herfindahl = roundTo(c.Herfindahl, 4)
+	c.TopNWeight = roundTo(c.TopNWeight, 2)
+	c.Total = roundTo(c.Total, 2)
+	return c
+}
+
+// buildRiskReport measures single-name concentration on positions combined
+// across portfolios, so holding one stock in two accounts counts once.
+func buildRiskReport(holdings []PortfolioHolding, settings RiskSettings) RiskReport {
+	values := map[string]float64{}
+	incomes := map[string]float64{}
+	for _, p := range aggregatePositions(holdings) {
+		values[p.Ticker] = p.TotalValue
+		incomes[p.Ticker] = p.MonthlyDividend * 12
+	}
+
+	report := RiskReport{
+		Settings: settings,
+		ByValue:  concentration(values, settings.TopN),
+		ByIncome: concentration(incomes, settings.TopN),
+		Sectors:  computeAllocation(holdings, "sector").Buckets,
+		Breaches: []RiskBreach{},
+	}
+
+	breach := func(rule, subject string, value, limit float64) {
+		if value > limit {
+			report.Breaches = append(report.Breaches, RiskBreach{Rule: rule, Subject: subject, Value: value, Limit: limit})
+		}
+	}
+
+	// ByValue.Top only holds the top N, so check every name here
+	for _, n := range concentration(values, len(values)).Top {
+		breach("max_position_weight", n.Key, n.Weight, settings.MaxPositionWeight)
+	}
+	for _, n := range concentration(incomes, len(incomes)).Top {
+		breach("max_position_income_weight", n.Key, n.Weight, settings.MaxPositionIncomeWeight)
+	}
+	for _, b := range report.Sectors {
+		breach("max_sector_weight", b.Key, b.ValueWeight, settings.MaxSectorWeight)
+		breach("max_sector_income_weight", b.Key, b.IncomeWeight, settings.MaxSectorIncomeWeight)
+	}
+	breach("max_herfindahl", "portfolio", report.ByValue.Herfindahl, settings.MaxHerfindahl)
+
+	return report
+}
+
+func registerRiskRoutes(protected *gin.RouterGroup) {
+	protected.GET("/risk", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+
+		settings, err := getRiskSettings(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if s := c.Query("top"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "top must be a positive integer"})
+				return
+			}
+			settings.TopN = n
+		}
+
+		portfolioID := c.Query("portfolio_id")
+		holdings, err := getScopedHoldings(userID, portfolioID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		report := buildRiskReport(holdings, settings)
+		report.PortfolioID = portfolioID
+		c.JSON(http.StatusOK, report)
+	})
+
+	protected.GET("/risk/settings", func(c *gin.Context) {
+		settings, err := getRiskSettings(c.GetString("user_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, settings)
+	})
+
+	protected.PUT("/risk/settings", func(c *gin.Context) {
+		settings := defaultRiskSettings
+		if err := c.ShouldBindJSON(&settings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := saveRiskSettings(c.GetString("user_id"), settings); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, settings)
+	})
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fundamentalsMaxAge is how long stored fundamentals are used before they
+// are fetched again. Statements only change when a company reports, so a
+// weekly refresh is plenty.
+const fundamentalsMaxAge = 7 * 24 * time.Hour
+
+// Safety ratings by score, highest first.
+const (
+	SafetyVerySafe   = "very_safe"
+	SafetySafe       = "safe"
+	SafetyBorderline = "borderline"
+	SafetyUnsafe     = "unsafe"
+	SafetyVeryUnsafe = "very_unsafe"
+)
+
+// Safety score components and their weights. Components that cannot be
+// computed are left out and the others reweighted to add up to 100.
+const (
+	SafetyEarningsPayout = "earnings_payout"
+	SafetyFCFPayout      = "fcf_payout"
+	SafetyGrowth         = "dividend_growth"
+	SafetyCuts           = "cut_history"
+)
+
+var safetyWeights = map[string]float64{
+	SafetyEarningsPayout: 25,
+	SafetyFCFPayout:      30,
+	SafetyGrowth:         20,
+	SafetyCuts:           25,
+}
+
+// Points for a payout ratio (percent) and for dividend growth (CAGR in
+// percent), interpolated linearly between the listed values and flat
+// beyond the ends.
+var (
+	payoutPoints = [][2]float64{{50, 100}, {75, 60}, {100, 20}, {150, 0}}
+	growthPoints = [][2]float64{{-10, 0}, {0, 40}, {5, 80}, {10, 100}}
+)
+
+// Cut history looks back safetyCutYears full calendar years; a year whose
+// dividend run rate fell more than safetyCutThreshold below the year before
+// is a cut, and growth is measured over at most safetyGrowthYears. A year
+// without an ex-date is a late payment rather than a cut when the next one
+// follows within safetySlipDays.
+const (
+	safetyCutYears     = 10
+	safetyCutThreshold = 0.10
+	safetyGrowthYears  = 5
+	safetySlipDays     = 60
+)
+
+// Fundamentals are the latest annual figures a payout ratio needs. Fields
+// are nil for securities without financial statements, such as funds.
+type Fundamentals struct {
+	FiscalDate    string   `json:"fiscal_date,omitempty"`
+	EPS           *float64 `json:"eps"`
+	FreeCashFlow  *float64 `json:"free_cash_flow"`
+	DilutedShares *float64 `json:"diluted_shares"`
+}
+
+// fetchFMPStatement reads the latest annual statement of the given kind
+// (income-statement or cash-flow-statement) into out.
+func fetchFMPStatement(kind, symbol, apiKey string, out interface{}) error {
+	url := fmt.Sprintf("https://financialmodelingprep.com/api/v3/%s/%s?period=annual&limit=1&apikey=%s", kind, symbol, apiKey)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s from FMP: %v", kind, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("FMP %s API returned status %d", kind, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse FMP %s response: %v", kind, err)
+	}
+	return nil
+}
+
+func fetchFMPFundamentals(symbol, apiKey string) (*Fundamentals, error) {
+	var income []struct {
+		Date                     string  `json:"date"`
+		EPSDiluted               float64 `json:"epsdiluted"`
+		WeightedAverageShsOutDil float64 `json:"weightedAverageShsOutDil"`
+	}
+	if err := fetchFMPStatement("income-statement", symbol, apiKey, &income); err != nil {
+		return nil, err
+	}
+	var cashFlow []struct {
+		Date         string  `json:"date"`
+		FreeCashFlow float64 `json:"freeCashFlow"`
+	}
+	if err := fetchFMPStatement("cash-flow-statement", symbol, apiKey, &cashFlow); err != nil {
+		return nil, err
+	}
+
+	f := &Fundamentals{}
+	if len(income) > 0 {
+		f.FiscalDate = income[0].Date
+		f.EPS = &income[0].EPSDiluted
+		if income[0].WeightedAverageShsOutDil > 0 {
+			f.DilutedShares = &income[0].WeightedAverageShsOutDil
+		}
+	}
+	// Only pair cash flow with shares from the same fiscal year
+	if len(cashFlow) > 0 && (f.FiscalDate == "" || cashFlow[0].Date == f.FiscalDate) {
+		f.FiscalDate = cashFlow[0].Date
+		f.FreeCashFlow = &cashFlow[0].FreeCashFlow
+	}
+	return f, nil
+}
+
+// getFundamentals returns symbol's stored fundamentals, refreshing them
+// from the provider when older than fundamentalsMaxAge.
+func getFundamentals(symbol, apiKey string) (*Fundamentals, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot load fundamentals")
+	}
+
+	var f Fundamentals
+	var fiscalDate sql.NullTime
+	var fetchedAt time.Time
+	err := db.QueryRow(`
+		SELECT fiscal_date, eps, free_cash_flow, diluted_shares, fetched_at
+		FROM fundamentals WHERE symbol = $1
+	`, symbol).Scan(&fiscalDate, &f.EPS, &f.FreeCashFlow, &f.DilutedShares, &fetchedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to load fundamentals: %v", err)
+	}
+	if err == nil && time.Since(fetchedAt) <= fundamentalsMaxAge {
+		if fiscalDate.Valid {
+			f.FiscalDate = fiscalDate.Time.Format(dateLayout)
+		}
+		return &f, nil
+	}
+
+	fetched, err := fetchFMPFundamentals(symbol, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`
+		INSERT INTO fundamentals (symbol, fiscal_date, eps, free_cash_flow, diluted_shares, fetched_at)
+		VALUES ($1, NULLIF($2, '')::date, $3, $4, $5, NOW())
+		ON CONFLICT (symbol) DO UPDATE
+		SET fiscal_date = EXCLUDED.fiscal_date, eps = EXCLUDED.eps, free_cash_flow = EXCLUDED.free_cash_flow,
+			diluted_shares = EXCLUDED.diluted_shares, fetched_at = NOW()
+	`, symbol, fetched.FiscalDate, fetched.EPS, fetched.FreeCashFlow, fetched.DilutedShares)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store fundamentals: %v", err)
+	}
+	return fetched, nil
+}
+
+// interpolatePoints maps x onto a piecewise linear curve.
+func interpolatePoints(points [][2]float64, x float64) float64 {
+	if x <= points[0][0] {
+		return points[0][1]
+	}
+	for i := 1; i < len(points); i++ {
+		if x <= points[i][0] {
+			a, b := points[i-1], points[i]
+			return a[1] + (b[1]-a[1])*(x-a[0])/(b[0]-a[0])
+		}
+	}
+	return points[len(points)-1][1]
+}
+
+func safetyRating(score int) string {
+	switch {
+	case score >= 80:
+		return SafetyVerySafe
+	case score >= 60:
+		return SafetySafe
+	case score >= 40:
+		return SafetyBorderline
+	case score >= 20:
+		return SafetyUnsafe
+	default:
+		return SafetyVeryUnsafe
+	}
+}
+
+type SafetyComponent struct {
+	Name   string  `json:"name"`
+	Weight float64 `json:"weight"`
+	Points float64 `json:"points"`
+}
+
+// DividendSafety scores how sustainable a symbol's dividend is, from 0
+// (likely to be cut) to 100. Payout ratios are the trailing twelve-month
+// dividend over the latest fiscal year's diluted EPS and free cash flow per
+// share. A loss or negative free cash flow leaves the ratio nil and scores
+// that component 0. Score is nil for symbols that paid no dividend in the
+// last year or have nothing to score.
+type DividendSafety struct {
+	Ticker              string            `json:"ticker"`
+	HoldingID           string            `json:"holding_id,omitempty"`
+	PortfolioID         string            `json:"portfolio_id,omitempty"`
+	WatchlistID         string            `json:"watchlist_id,omitempty"`
+	Score               *int              `json:"score"`
+	Rating              string            `json:"rating,omitempty"`
+	AnnualDividend      float64           `json:"annual_dividend"`
+	Fundamentals        *Fundamentals     `json:"fundamentals"`
+	EarningsPayoutRatio *float64          `json:"earnings_payout_ratio"`
+	FCFPayoutRatio      *float64          `json:"fcf_payout_ratio"`
+	DividendGrowth      *float64          `json:"dividend_growth"`
+	GrowthYears         int               `json:"growth_years"`
+	Cuts                int               `json:"cuts"`
+	LastCutYear         int               `json:"last_cut_year,omitempty"`
+	Components          []SafetyComponent `json:"components"`
+}
+
+// dividendRunRates returns the annual dividend rate at the end of each year
+// from first to last: the median payment of the trailing twelve months
+// times the payments per year implied by the median gap between ex-dates.
+// An ex-date slipping into the next year changes both years' counts but
+// not their rates. A year without payments is 0, or left out when the next
+// payment came within safetySlipDays.
+func dividendRunRates(events []DividendEvent, first, last int) map[int]float64 {
+	type payment struct {
+		date   time.Time
+		amount float64
+	}
+	var payments []payment
+	for _, e := range events {
+		t, err := time.Parse(dateLayout, e.ExDate)
+		if err != nil {
+			continue
+		}
+		amount := e.AdjAmount
+		if amount == 0 {
+			amount = e.Amount
+		}
+		payments = append(payments, payment{t, amount})
+	}
+	sort.Slice(payments, func(i, j int) bool { return payments[i].date.Before(payments[j].date) })
+
+	rates := map[int]float64{}
+	for y := first; y <= last; y++ {
+		start := time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)
+		end := start.AddDate(1, 0, 0)
+		var amounts, gaps []float64
+		next := -1
+		for i, p := range payments {
+			if !p.date.Before(end) {
+				next = i
+				break
+			}
+			if p.date.Before(start) {
+				continue
+			}
+			amounts = append(amounts, p.amount)
+			if i > 0 {
+				gaps = append(gaps, p.date.Sub(payments[i-1].date).Hours()/24)
+			}
+		}
+
+		if len(amounts) == 0 {
+			if next < 0 || payments[next].date.Sub(end) > safetySlipDays*24*time.Hour {
+				rates[y] = 0
+			}
+			continue
+		}
+		perYear := 1.0
+		if len(gaps) > 0 {
+			sort.Float64s(gaps)
+			if gap := percentile(gaps, 50); gap > 0 {
+				perYear = math.Max(1, math.Round(365.25/gap))
+			}
+		}
+		sort.Float64s(amounts)
+		rates[y] = percentile(amounts, 50) * perYear
+	}
+	return rates
+}
+
+// scoreDividendSafety combines payout ratios, dividend growth and cuts
+// into a weighted score. f may be nil.
+func scoreDividendSafety(ticker string, events []DividendEvent, f *Fundamentals, now time.Time) DividendSafety {
+	s := DividendSafety{Ticker: ticker, Fundamentals: f, Components: []SafetyComponent{}}
+
+	yearAgo := now.AddDate(-1, 0, 0)
+	firstYear := 0
+	for _, e := range events {
+		t, err := time.Parse(dateLayout, e.ExDate)
+		if err != nil {
+			continue
+		}
+		if firstYear == 0 || t.Year() < firstYear {
+			firstYear = t.Year()
+		}
+		if t.After(yearAgo) {
+			s.AnnualDividend += e.AdjAmount
+		}
+	}
+	s.AnnualDividend = roundTo(s.AnnualDividend, 4)
+	if s.AnnualDividend <= 0 {
+		return s
+	}
+
+	points := map[string]float64{}
+	if f != nil && f.EPS != nil {
+		if *f.EPS > 0 {
+			ratio := roundTo(s.AnnualDividend / *f.EPS * 100, 2)
+			s.EarningsPayoutRatio = &ratio
+			points[SafetyEarningsPayout] = interpolatePoints(payoutPoints, ratio)
+		} else {
+			points[SafetyEarningsPayout] = 0
+		}
+	}
+	if f != nil && f.FreeCashFlow != nil && f.DilutedShares != nil {
+		if perShare := *f.FreeCashFlow / *f.DilutedShares; perShare > 0 {
+			ratio := roundTo(s.AnnualDividend/perShare*100, 2)
+			s.FCFPayoutRatio = &ratio
+			points[SafetyFCFPayout] = interpolatePoints(payoutPoints, ratio)
+		} else {
+			points[SafetyFCFPayout] = 0
+		}
+	}
+
+	// Growth and cuts use full calendar years only
+	lastYear := now.Year() - 1
+	if years := lastYear - firstYear; years >= 1 {
+		if years > safetyGrowthYears {
+			years = safetyGrowthYears
+		}
+		if cagr, ok := dividendCAGR(events, lastYear, years); ok {
+			cagr = roundTo(cagr, 2)
+			s.DividendGrowth, s.GrowthYears = &cagr, years
+			points[SafetyGrowth] = interpolatePoints(growthPoints, cagr)
+		}
+
+		rates := dividendRunRates(events, lastYear-safetyCutYears, lastYear)
+		prev, hasPrev := 0.0, false
+		for y := lastYear - safetyCutYears; y <= lastYear; y++ {
+			rate, ok := rates[y]
+			if !ok || y < firstYear {
+				continue
+			}
+			if hasPrev && y > lastYear-safetyCutYears && prev > 0 && rate < prev*(1-safetyCutThreshold) {
+				s.Cuts++
+				s.LastCutYear = y
+			}
+			prev, hasPrev = rate, true
+		}
+		switch since := lastYear - s.LastCutYear; {
+		case s.Cuts == 0:
+			points[SafetyCuts] = 100
+		case since < 2:
+			points[SafetyCuts] = 0
+		case since < 5:
+			points[SafetyCuts] = 30
+		default:
+			points[SafetyCuts] = 60
+		}
+	}
+
+	var weight, total float64
+	for _, name := range []string{SafetyEarningsPayout, SafetyFCFPayout, SafetyGrowth, SafetyCuts} {
+		p, ok := points[name]
+		if !ok {
+			continue
+		}
+		weight += safetyWeights[name]
+		total += safetyWeights[name] * p
+		s.Components = append(s.Components, SafetyComponent{Name: name, Weight: safetyWeights[name], Points: roundTo(p, 1)})
+	}
+	if weight == 0 {
+		return s
+	}
+	score := int(total/weight + 0.5)
+	s.Score, s.Rating = &score, safetyRating(score)
+	return s
+}
+
+type SafetyReport struct {
+	Scores   []DividendSafety `json:"scores"`
+	Warnings []string         `json:"warnings"`
+}
+
+// safetyScorer scores each symbol once, collecting provider failures as
+// warnings; a symbol with missing data is scored on what is available.
+type safetyScorer struct {
+	apiKey   string
+	scores   map[string]DividendSafety
+	warnings []string
+}
+
+func newSafetyScorer(apiKey string) *safetyScorer {
+	return &safetyScorer{apiKey: apiKey, scores: map[string]DividendSafety{}, warnings: []string{}}
+}
+
+func (s *safetyScorer) score(ticker string) DividendSafety {
+	if score, ok := s.scores[ticker]; ok {
+		return score
+	}
+	events, err := getDividendHistory(ticker, s.apiKey)
+	if err != nil {
+		s.warnings = append(s.warnings, fmt.Sprintf("no dividend history for %s: %v", ticker, err))
+	}
+	f, err := getFundamentals(ticker, s.apiKey)
+	if err != nil {
+		s.warnings = append(s.warnings, fmt.Sprintf("no fundamentals for %s: %v", ticker, err))
+	}
+	score := scoreDividendSafety(ticker, events, f, time.Now())
+	s.scores[ticker] = score
+	return score
+}
+
+func getHoldingSafety(userID, portfolioID, apiKey string) (*SafetyReport, error) {
+	holdings, err := getRawScopedHoldings(userID, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	scorer := newSafetyScorer(apiKey)
+	report := &SafetyReport{Scores: []DividendSafety{}}
+	for _, h := range holdings {
+		score := scorer.score(h.Ticker)
+		score.HoldingID, score.PortfolioID = h.ID, h.PortfolioID
+		report.Scores = append(report.Scores, score)
+	}
+	sort.SliceStable(report.Scores, func(i, j int) bool { return report.Scores[i].Ticker < report.Scores[j].Ticker })
+	report.Warnings = scorer.warnings
+	return report, nil
+}
+
+func getWatchlistSafety(userID, apiKey string) (*SafetyReport, error) {
+	entries, err := getWatchlist(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	scorer := newSafetyScorer(apiKey)
+	report := &SafetyReport{Scores: []DividendSafety{}}
+	for _, w := range entries {
+		score := scorer.score(w.Ticker)
+		score.WatchlistID = w.ID
+		report.Scores = append(report.Scores, score)
+	}
+	report.Warnings = scorer.warnings
+	return report, nil
+}
+
+func registerSafetyRoutes(protected *gin.RouterGroup, apiKey string) {
+	protected.GET("/safety", func(c *gin.Context) {
+		report, err := getHoldingSafety(c.GetString("user_id"), c.Query("portfolio_id"), apiKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// quarterly returns ex-dates on the 15th of Mar, Jun, Sep and Dec from
+// first to last year, with amount(year) per payment.
+func quarterly(first, last int, amount func(year int) float64) []DividendEvent {
+	var events []DividendEvent
+	for y := first; y <= last; y++ {
+		for _, m := range []int{3, 6, 9, 12} {
+			events = append(events, DividendEvent{ExDate: fmt.Sprintf("%d-%02d-15", y, m), AdjAmount: amount(y)})
+		}
+	}
+	return events
+}
+
+func TestScoreDividendSafetyCuts(t *testing.T) {
+	now := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	flat := func(int) float64 { return 1 }
+
+	slipped := quarterly(2015, 2024, flat)
+	for i := range slipped {
+		if slipped[i].ExDate == "2021-12-15" {
+			slipped[i].ExDate = "2022-01-03"
+		}
+	}
+
+	annual := []DividendEvent{}
+	for y := 2015; y <= 2024; y++ {
+		date := fmt.Sprintf("%d-12-20", y)
+		if y == 2020 {
+			date = "2021-01-05"
+		}
+		annual = append(annual, DividendEvent{ExDate: date, AdjAmount: 2})
+	}
+
+	suspended := []DividendEvent{}
+	for _, e := range quarterly(2015, 2024, flat) {
+		if e.ExDate[:4] != "2020" {
+			suspended = append(suspended, e)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		events      []DividendEvent
+		wantCuts    int
+		wantLastCut int
+	}{
+		{"steady", quarterly(2015, 2024, flat), 0, 0},
+		{"ex-date slips into January", slipped, 0, 0},
+		{"annual payment slips into January", annual, 0, 0},
+		{"cut by half", quarterly(2015, 2024, func(y int) float64 {
+			if y >= 2022 {
+				return 0.5
+			}
+			return 1
+		}), 1, 2022},
+		{"suspended for a year", suspended, 1, 2020},
+		{"special dividend", append(quarterly(2015, 2024, flat), DividendEvent{ExDate: "2019-12-28", AdjAmount: 3}), 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := scoreDividendSafety("TEST", tt.events, nil, now)
+			if s.Cuts != tt.wantCuts || s.LastCutYear != tt.wantLastCut {
+				t.Errorf("cuts = %d (last %d), want %d (last %d)", s.Cuts, s.LastCutYear, tt.wantCuts, tt.wantLastCut)
+			}
+		})
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // the alpine runtime image ships without zoneinfo
+)
+
+// Scheduled jobs run inside the API process. Cron expressions are evaluated
+// in the exchange's time zone so "15 16 * * 1-5" means 4:15pm New York time.
+const (
+	defaultRefreshSchedule = "15 16 * * 1-5"
+	marketTimeZone         = "America/New_York"
+	// marketCloseGrace lets a market-hours-only job run shortly after the
+	// close so the closing prices are captured.
+	marketCloseGrace = 30 * time.Minute
+)
+
+var marketLocation = mustLoadLocation(marketTimeZone)
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load time zone %s: %v", name, err))
+	}
+	return loc
+}
+
+// cronField is a bitset of the values a cron field matches.
+type cronField uint64
+
+func (f cronField) has(v int) bool {
+	return f&(1<<uint(v)) != 0
+}
+
+// CronSchedule is a parsed five-field cron expression:
+// minute hour day-of-month month day-of-week.
+type CronSchedule struct {
+	expr    string
+	minute  cronField
+	hour    cronField
+	dom     cronField
+	month   cronField
+	dow     cronField
+	domStar bool
+	dowStar bool
+}
+
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseCronSchedule accepts the usual cron syntax: *, lists (1,15), ranges
+// (1-5), steps (*/15, 9-17/2) and the @hourly/@daily/@weekly/@monthly macros.
+// Day-of-week runs 0-6 with Sunday as 0 (7 is also accepted for Sunday).
+func parseCronSchedule(expr string) (*CronSchedule, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[spec]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	s := &CronSchedule{expr: expr, domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	bounds := []struct {
+		dest     *cronField
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		f, err := parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expr, err)
+		}
+		*b.dest = f
+	}
+	if s.dow.has(7) {
+		s.dow |= 1
+	}
+
+	return s, nil
+}
+
+func parseCronField(field string, min, max int) (cronField, error) {
+	var f cronField
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart, step = part[:i], n
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				// "5/15" means every 15 starting at 5
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value %q out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			f |= 1 << uint(v)
+		}
+	}
+	return f, nil
+}
+
+// dayMatches applies cron's rule that when both day-of-month and day-of-week
+// are restricted a day matching either one qualifies.
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom.has(t.Day())
+	dowMatch := s.dow.has(int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first matching minute strictly after t, in t's location.
+// It returns the zero time if nothing matches within five years.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !s.month.has(int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.hour.has(t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !s.minute.has(t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *CronSchedule) String() string {
+	return s.expr
+}
+
+// marketOpen reports whether t falls in the NYSE regular session
+// (9:30am-4:00pm New York time, Monday to Friday) extended by grace after
+// the close. Exchange holidays are not modelled.
+func marketOpen(t time.Time, grace time.Duration) bool {
+	local := t.In(marketLocation)
+	if local.Weekday() == time.Saturday || local.Weekday() == time.Sunday {
+		return false
+	}
+	open := time.Date(local.Year(), local.Month(), local.Day(), 9, 30, 0, 0, marketLocation)
+	close := time.Date(local.Year(), local.Month(), local.Day(), 16, 0, 0, 0, marketLocation).Add(grace)
+	return !local.Before(open) && local.Before(close)
+}
+
+type scheduledJob struct {
+	name            string
+	schedule        *CronSchedule
+	marketHoursOnly bool
+	run             func(ctx context.Context) error
+}
+
+// Scheduler runs background jobs on cron schedules. Each run first takes a
+// Postgres advisory lock keyed on the job name and claims its scheduled
+// slot in scheduler_runs, so that when several backend replicas share a
+// database only one of them does the work, even if their ticks drift.
+type Scheduler struct {
+	jobs []scheduledJob
+}
+
+func (s *Scheduler) Add(job scheduledJob) {
+	s.jobs = append(s.jobs, job)
+}
+
+// Start launches one goroutine per job; they stop when ctx is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, job := range s.jobs {
+		fmt.Printf("Scheduling job %s with schedule %q (market hours only: %t)\n", job.name, job.schedule, job.marketHoursOnly)
+		go s.loop(ctx, job)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, job scheduledJob) {
+	for {
+		next := job.schedule.Next(time.Now().In(marketLocation))
+		if next.IsZero() {
+			fmt.Printf("Warning: job %s has no upcoming run, stopping\n", job.name)
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if job.marketHoursOnly && !marketOpen(time.Now(), marketCloseGrace) {
+			fmt.Printf("Skipping job %s: market closed\n", job.name)
+			continue
+		}
+
+		s.runOnce(ctx, job, next)
+	}
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, job scheduledJob, slot time.Time) {
+	start := time.Now()
+	ran, err := withAdvisoryLock(ctx, advisoryLockKey(job.name), job.name, slot, job.run)
+	switch {
+	case err != nil:
+		fmt.Printf("Warning: job %s failed after %s: %v\n", job.name, time.Since(start).Round(time.Millisecond), err)
+	case !ran:
+		fmt.Printf("Skipping job %s: another instance holds the lock or already ran the %s slot\n", job.name, slot.Format(time.RFC3339))
+	default:
+		fmt.Printf("Job %s finished in %s\n", job.name, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// advisoryLockKey maps a job name onto the bigint key space of
+// pg_try_advisory_xact_lock.
+func advisoryLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("dividend_tracker:" + name))
+	return int64(h.Sum64())
+}
+
+// withAdvisoryLock runs fn only if this process wins the advisory lock for
+// key and no replica has already run job for slot. A transaction-scoped
+// lock is used rather than a session lock because it works through
+// Supavisor's transaction pooler as well as direct and session connections;
+// the transaction is held open (doing nothing else) until fn returns. The
+// lock alone only stops overlapping runs, so the slot is recorded in the
+// same transaction and committed when fn succeeds; a failed run rolls back
+// and leaves the slot for another replica to retry.
+func withAdvisoryLock(ctx context.Context, key int64, job string, slot time.Time, fn func(ctx context.Context) error) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("database unavailable - cannot acquire lock")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin lock transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var acquired bool
+	if err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", key).Scan(&acquired); err != nil {
+		return false, fmt.Errorf("failed to acquire advisory lock: %v", err)
+	}
+	if !acquired {
+		return false, nil
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO scheduler_runs (job, scheduled_for, started_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (job, scheduled_for) DO NOTHING
+	`, job, slot)
+	if err != nil {
+		return false, fmt.Errorf("failed to record job run: %v", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	if err := fn(ctx); err != nil {
+		return true, err
+	}
+	if err := tx.Commit(); err != nil {
+		return true, fmt.Errorf("failed to commit job run: %v", err)
+	}
+	return true, nil
+}
+
+// queryUserIDs runs a query returning a single user_id column.
+func queryUserIDs(ctx context.Context, query string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %v", err)
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan user id: %v", err)
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
+
+// refreshAllUsers is the scheduled counterpart of POST /portfolio/refresh and
+// POST /watchlist/refresh, applied to every user.
+func refreshAllUsers(ctx context.Context, apiKey string) error {
+	// Every user that has at least one holding or watchlist entry
+	userIDs, err := queryUserIDs(ctx, `
+		SELECT user_id FROM portfolio_holdings
+		UNION
+		SELECT user_id FROM watchlist
+	`)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := refreshHoldings(apiKey, userID); err != nil {
+			fmt.Printf("Warning: failed to refresh holdings for user %s: %v\n", userID, err)
+		}
+		if err := refreshWatchlist(apiKey, userID); err != nil {
+			fmt.Printf("Warning: failed to refresh watchlist for user %s: %v\n", userID, err)
+		}
+	}
+
+	fmt.Printf("Refreshed portfolios for %d users\n", len(userIDs))
+	return nil
+}
+
+// envBool reads a boolean environment variable, falling back to def when it
+// is unset or unparsable.
+func envBool(name string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// scheduleFromEnv parses the cron expression in the named environment
+// variable, falling back to def when it is unset.
+func scheduleFromEnv(name, def string) (*CronSchedule, error) {
+	expr := os.Getenv(name)
+	if expr == "" {
+		expr = def
+	}
+	schedule, err := parseCronSchedule(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return schedule, nil
+}
+
+// newSchedulerFromEnv builds the scheduler from SCHEDULER_ENABLED and the
+// per-job schedule variables. It returns nil when the scheduler is disabled.
+func newSchedulerFromEnv(apiKey string) (*Scheduler, error) {
+	if !envBool("SCHEDULER_ENABLED", true) {
+		return nil, nil
+	}
+
+	refreshSchedule, err := scheduleFromEnv("REFRESH_SCHEDULE", defaultRefreshSchedule)
+	if err != nil {
+		return nil, err
+	}
+	snapshotSchedule, err := scheduleFromEnv("SNAPSHOT_SCHEDULE", defaultSnapshotSchedule)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Scheduler{}
+	s.Add(scheduledJob{
+		name:            "refresh-holdings",
+		schedule:        refreshSchedule,
+		marketHoursOnly: envBool("REFRESH_MARKET_HOURS_ONLY", true),
+		run: func(ctx context.Context) error {
+			return refreshAllUsers(ctx, apiKey)
+		},
+	})
+	s.Add(scheduledJob{
+		name:     "portfolio-snapshots",
+		schedule: snapshotSchedule,
+		run:      snapshotAllUsers,
+	})
+	return s, nil
+}
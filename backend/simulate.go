@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Simulation change actions.
+const (
+	SimulateAdd            = "add"
+	SimulateSell           = "sell"
+	SimulateSetShares      = "set_shares"
+	SimulateDividendChange = "dividend_change"
+)
+
+// SimulationChange is one hypothetical edit. Shares applies to add, sell and
+// set_shares; a sell without shares sells the whole position. Percent applies
+// to dividend_change, so -20 cuts the dividend by a fifth.
+type SimulationChange struct {
+	Action  string  `json:"action" binding:"required,oneof=add sell set_shares dividend_change"`
+	Ticker  string  `json:"ticker" binding:"required"`
+	Shares  int     `json:"shares" binding:"min=0"`
+	Percent float64 `json:"percent" binding:"min=-100"`
+}
+
+type SimulationRequest struct {
+	PortfolioID string             `json:"portfolio_id"`
+	Changes     []SimulationChange `json:"changes" binding:"required,min=1,dive"`
+}
+
+// simulationError is a change that cannot be applied to the portfolio, as
+// opposed to a failure loading or pricing it.
+type simulationError string
+
+func (e simulationError) Error() string { return string(e) }
+
+type SimulationMetrics struct {
+	TotalValue    float64 `json:"total_value"`
+	DividendYield float64 `json:"dividend_yield"`
+	MonthlyIncome float64 `json:"monthly_income"`
+	AnnualIncome  float64 `json:"annual_income"`
+}
+
+// AllocationDelta is the change in one group's share of total value, in
+// percentage points.
+type AllocationDelta struct {
+	Key    string  `json:"key"`
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+	Delta  float64 `json:"delta"`
+}
+
+type SimulationResult struct {
+	PortfolioID  string             `json:"portfolio_id,omitempty"`
+	BaseCurrency string             `json:"base_currency"`
+	Rates        []FXRate           `json:"rates"`
+	Before       SimulationMetrics  `json:"before"`
+	After        SimulationMetrics  `json:"after"`
+	Delta        SimulationMetrics  `json:"delta"`
+	Holdings     []AllocationDelta  `json:"holdings"`
+	Sectors      []AllocationDelta  `json:"sectors"`
+	Changes      []SimulationChange `json:"changes"`
+}
+
+func simulationMetrics(positions []*rebalancePosition) SimulationMetrics {
+	var m SimulationMetrics
+	for _, p := range positions {
+		m.TotalValue += p.value()
+		m.AnnualIncome += float64(p.Shares) * p.AnnualDividend
+	}
+	if m.TotalValue > 0 {
+		m.DividendYield = roundTo(m.AnnualIncome/m.TotalValue*100, 2)
+	}
+	m.MonthlyIncome = roundTo(m.AnnualIncome/12, 2)
+	m.AnnualIncome = roundTo(m.AnnualIncome, 2)
+	m.TotalValue = roundTo(m.TotalValue, 2)
+	return m
+}
+
+// valueWeights returns each group's percentage of total value.
+func valueWeights(positions []*rebalancePosition, by string) map[string]float64 {
+	var total float64
+	weights := map[string]float64{}
+	for _, p := range positions {
+		total += p.value()
+		weights[p.group(by)] += p.value()
+	}
+	for key, v := range weights {
+		if total > 0 {
+			weights[key] = v / total * 100
+		} else {
+			weights[key] = 0
+		}
+	}
+	return weights
+}
+
+// allocationDeltas lists every group present before or after, largest move
+// first. Groups whose weight did not change are included.
+func allocationDeltas(before, after []*rebalancePosition, by string) []AllocationDelta {
+	b, a := valueWeights(before, by), valueWeights(after, by)
+	keys := map[string]bool{}
+	for k := range b {
+		keys[k] = true
+	}
+	for k := range a {
+		keys[k] = true
+	}
+
+	deltas := []AllocationDelta{}
+	for k := range keys {
+		deltas = append(deltas, AllocationDelta{
+			Key:    k,
+			Before: roundTo(b[k], 2),
+			After:  roundTo(a[k], 2),
+			Delta:  roundTo(a[k]-b[k], 2),
+		})
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		di, dj := deltas[i].Delta, deltas[j].Delta
+		if di < 0 {
+			di = -di
+		}
+		if dj < 0 {
+			dj = -dj
+		}
+		if di != dj {
+			return di > dj
+		}
+		return deltas[i].Key < deltas[j].Key
+	})
+	return deltas
+}
+
+// clonePositions copies positions so changes can be applied without
+// touching the originals.
+func clonePositions(positions []*rebalancePosition) []*rebalancePosition {
+	out := make([]*rebalancePosition, len(positions))
+	for i, p := range positions {
+		c := *p
+		out[i] = &c
+	}
+	return out
+}
+
+// applySimulation applies changes in order. Tickers bought that are not yet
+// held must already be in quotes.
+func applySimulation(positions []*rebalancePosition, changes []SimulationChange, quotes map[string]*rebalancePosition) ([]*rebalancePosition, error) {
+	byTicker := map[string]*rebalancePosition{}
+	for _, p := range positions {
+		byTicker[p.Ticker] = p
+	}
+
+	for _, ch := range changes {
+		p, held := byTicker[ch.Ticker]
+		if !held {
+			if ch.Action != SimulateAdd && ch.Action != SimulateSetShares {
+				return nil, simulationError(ch.Ticker + " is not in the portfolio")
+			}
+			quote := *quotes[ch.Ticker]
+			p = &quote
+			byTicker[ch.Ticker] = p
+			positions = append(positions, p)
+		}
+
+		switch ch.Action {
+		case SimulateAdd:
+			if ch.Shares <= 0 {
+				return nil, simulationError("add " + ch.Ticker + " requires positive shares")
+			}
+			p.Shares += ch.Shares
+		case SimulateSell:
+			if ch.Shares > p.Shares {
+				return nil, simulationError(fmt.Sprintf("cannot sell %d shares of %s, only %d held", ch.Shares, ch.Ticker, p.Shares))
+			}
+			if ch.Shares == 0 {
+				p.Shares = 0
+			} else {
+				p.Shares -= ch.Shares
+			}
+		case SimulateSetShares:
+			p.Shares = ch.Shares
+		case SimulateDividendChange:
+			p.AnnualDividend *= 1 + ch.Percent/100
+		}
+	}
+
+	// Drop closed positions so they leave the allocation
+	kept := positions[:0]
+	for _, p := range positions {
+		if p.Shares > 0 {
+			kept = append(kept, p)
+		}
+	}
+	return kept, nil
+}
+
+func simulatePortfolio(userID string, req SimulationRequest, apiKey string) (*SimulationResult, error) {
+	before, conv, err := loadRebalancePositions(userID, req.PortfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	held := map[string]bool{}
+	for _, p := range before {
+		held[p.Ticker] = true
+	}
+
+	quotes := map[string]*rebalancePosition{}
+	for _, ch := range req.Changes {
+		if held[ch.Ticker] || quotes[ch.Ticker] != nil {
+			continue
+		}
+		if ch.Action != SimulateAdd && ch.Action != SimulateSetShares {
+			continue
+		}
+		p, err := quotePosition(ch.Ticker, apiKey, conv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to price %s: %v", ch.Ticker, err)
+		}
+		quotes[ch.Ticker] = p
+	}
+
+	after, err := applySimulation(clonePositions(before), req.Changes, quotes)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SimulationResult{
+		PortfolioID:  req.PortfolioID,
+		BaseCurrency: conv.base,
+		Rates:        conv.used,
+		Before:       simulationMetrics(before),
+		After:        simulationMetrics(after),
+		Holdings:     allocationDeltas(before, after, TargetHolding),
+		Sectors:      allocationDeltas(before, after, TargetSector),
+		Changes:      req.Changes,
+	}
+	result.Delta = SimulationMetrics{
+		TotalValue:    roundTo(result.After.TotalValue-result.Before.TotalValue, 2),
+		DividendYield: roundTo(result.After.DividendYield-result.Before.DividendYield, 2),
+		MonthlyIncome: roundTo(result.After.MonthlyIncome-result.Before.MonthlyIncome, 2),
+		AnnualIncome:  roundTo(result.After.AnnualIncome-result.Before.AnnualIncome, 2),
+	}
+	return result, nil
+}
+
+func registerSimulationRoutes(protected *gin.RouterGroup, apiKey string) {
+	protected.POST("/simulate", func(c *gin.Context) {
+		var req SimulationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for i := range req.Changes {
+			ticker, err := normalizeSymbol(req.Changes[i].Ticker)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			req.Changes[i].Ticker = ticker
+		}
+
+		result, err := simulatePortfolio(c.GetString("user_id"), req, apiKey)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if _, ok := err.(simulationError); ok {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultSnapshotSchedule takes the daily snapshot after the default
+// post-close refresh has had time to finish.
+const defaultSnapshotSchedule = "45 17 * * *"
+
+const dateLayout = "2006-01-02"
+
+// HistoryPoint is one entry of a portfolio value/income time series.
+type HistoryPoint struct {
+	Date          string  `json:"date"`
+	TotalValue    float64 `json:"total_value"`
+	AnnualIncome  float64 `json:"annual_income"`
+	DividendYield float64 `json:"dividend_yield"`
+	HoldingsCount int     `json:"holdings_count"`
+}
+
+// PortfolioHistory is in the base currency. Snapshots recorded in another
+// base currency are converted at current rates, listed in Rates.
+type PortfolioHistory struct {
+	From         string         `json:"from"`
+	To           string         `json:"to"`
+	Interval     string         `json:"interval"`
+	Ticker       string         `json:"ticker,omitempty"`
+	PortfolioID  string         `json:"portfolio_id,omitempty"`
+	BaseCurrency string         `json:"base_currency"`
+	Rates        []FXRate       `json:"rates"`
+	Points       []HistoryPoint `json:"points"`
+}
+
+// historyIntervals maps the interval query parameter onto date_trunc units.
+var historyIntervals = map[string]string{
+	"day":   "day",
+	"week":  "week",
+	"month": "month",
+}
+
+// snapshotUser records today's totals for one user and for each of their
+// holdings, in the user's base currency. Re-running on the same day
+// overwrites that day's rows. A holding that cannot be converted is left
+// out rather than losing the whole day.
+func snapshotUser(ctx context.Context, userID string, date time.Time) error {
+	raw, err := getRawScopedHoldings(userID, "")
+	if err != nil {
+		return err
+	}
+	base, err := getBaseCurrency(userID)
+	if err != nil {
+		return err
+	}
+	conv := newFXConversion(strings.ToUpper(base), fxSource)
+	var holdings []PortfolioHolding
+	for _, h := range raw {
+		converted, err := convertHoldings([]PortfolioHolding{h}, conv)
+		if err != nil {
+			fmt.Printf("Warning: leaving %s out of the snapshot for user %s: %v\n", h.Ticker, userID, err)
+			continue
+		}
+		holdings = append(holdings, converted[0])
+	}
+	totals := totalHoldings(holdings)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin snapshot transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO portfolio_snapshots (user_id, snapshot_date, total_value, annual_income, dividend_yield, holdings_count, base_currency, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		ON CONFLICT (user_id, snapshot_date) DO UPDATE
+		SET total_value = EXCLUDED.total_value, annual_income = EXCLUDED.annual_income,
+			dividend_yield = EXCLUDED.dividend_yield, holdings_count = EXCLUDED.holdings_count,
+			base_currency = EXCLUDED.base_currency, created_at = NOW()
+	`, userID, date, totals.TotalValue, totals.AnnualDividend, totals.DividendYield, totals.HoldingsCount, conv.base)
+	if err != nil {
+		return fmt.Errorf("failed to insert portfolio snapshot: %v", err)
+	}
+
+	for _, h := range holdings {
+		annualIncome := h.MonthlyDividend * 12
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO holding_snapshots (user_id, portfolio_id, holding_id, ticker, snapshot_date, shares, price, total_value, annual_income, dividend_yield, base_currency, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW())
+			ON CONFLICT (holding_id, snapshot_date) DO UPDATE
+			SET shares = EXCLUDED.shares, price = EXCLUDED.price, total_value = EXCLUDED.total_value,
+				annual_income = EXCLUDED.annual_income, dividend_yield = EXCLUDED.dividend_yield,
+				base_currency = EXCLUDED.base_currency, created_at = NOW()
+		`, userID, h.PortfolioID, h.ID, h.Ticker, date, h.Shares, h.CurrentPrice, h.TotalValue, annualIncome, h.DividendYield, conv.base)
+		if err != nil {
+			return fmt.Errorf("failed to insert holding snapshot for %s: %v", h.Ticker, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// snapshotAllUsers is the daily background job behind /portfolio/history.
+// Users with earlier snapshots are included after selling everything, so
+// their history drops to zero instead of stopping at the last value.
+func snapshotAllUsers(ctx context.Context) error {
+	userIDs, err := queryUserIDs(ctx, "SELECT user_id FROM portfolio_holdings UNION SELECT user_id FROM portfolio_snapshots")
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().In(marketLocation)
+	date := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	for _, userID := range userIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := snapshotUser(ctx, userID, date); err != nil {
+			fmt.Printf("Warning: failed to snapshot portfolio for user %s: %v\n", userID, err)
+		}
+	}
+
+	fmt.Printf("Captured portfolio snapshots for %d users\n", len(userIDs))
+	return nil
+}
+
+// getPortfolioHistory returns the last snapshot in each interval bucket
+// between from and to, converted into the user's base currency. When
+// ticker or portfolioID is given the series is built from holding
+// snapshots restricted to them. A day's snapshots share one currency.
+func getPortfolioHistory(userID string, from, to time.Time, interval, ticker, portfolioID string) ([]HistoryPoint, *fxConversion, error) {
+	if db == nil {
+		return nil, nil, fmt.Errorf("database unavailable - cannot load history")
+	}
+	base, err := getBaseCurrency(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	conv := newFXConversion(strings.ToUpper(base), fxSource)
+
+	unit := historyIntervals[interval]
+
+	var query string
+	args := []interface{}{userID, from, to}
+	if ticker == "" && portfolioID == "" {
+		query = `
+			SELECT DISTINCT ON (date_trunc('` + unit + `', snapshot_date))
+				snapshot_date, total_value, annual_income, dividend_yield, holdings_count, base_currency
+			FROM portfolio_snapshots
+			WHERE user_id = $1 AND snapshot_date BETWEEN $2 AND $3
+			ORDER BY date_trunc('` + unit + `', snapshot_date), snapshot_date DESC
+		`
+	} else {
+		filter := ""
+		if ticker != "" {
+			args = append(args, ticker)
+			filter += fmt.Sprintf(" AND ticker = $%d", len(args))
+		}
+		if portfolioID != "" {
+			args = append(args, portfolioID)
+			filter += fmt.Sprintf(" AND portfolio_id = $%d", len(args))
+		}
+		query = `
+			WITH daily AS (
+				SELECT snapshot_date, base_currency, SUM(total_value) AS total_value, SUM(annual_income) AS annual_income, COUNT(*) AS holdings_count
+				FROM holding_snapshots
+				WHERE user_id = $1 AND snapshot_date BETWEEN $2 AND $3` + filter + `
+				GROUP BY snapshot_date, base_currency
+			)
+			SELECT DISTINCT ON (date_trunc('` + unit + `', snapshot_date))
+				snapshot_date, total_value, annual_income,
+				CASE WHEN total_value > 0 THEN ROUND(annual_income / total_value * 100, 2) ELSE 0 END,
+				holdings_count, base_currency
+			FROM daily
+			ORDER BY date_trunc('` + unit + `', snapshot_date), snapshot_date DESC
+		`
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query history: %v", err)
+	}
+	defer rows.Close()
+
+	points := []HistoryPoint{}
+	for rows.Next() {
+		var p HistoryPoint
+		var date time.Time
+		var currency string
+		if err := rows.Scan(&date, &p.TotalValue, &p.AnnualIncome, &p.DividendYield, &p.HoldingsCount, &currency); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan history: %v", err)
+		}
+		p.Date = date.Format(dateLayout)
+		if p.TotalValue, err = conv.convert(p.TotalValue, currency); err != nil {
+			return nil, nil, fmt.Errorf("failed to convert snapshot of %s: %v", p.Date, err)
+		}
+		if p.AnnualIncome, err = conv.convert(p.AnnualIncome, currency); err != nil {
+			return nil, nil, fmt.Errorf("failed to convert snapshot of %s: %v", p.Date, err)
+		}
+		p.TotalValue, p.AnnualIncome = roundTo(p.TotalValue, 2), roundTo(p.AnnualIncome, 2)
+		points = append(points, p)
+	}
+
+	return points, conv, rows.Err()
+}
+
+// parseDateRange reads the from/to query parameters (YYYY-MM-DD). to defaults
+// to today and from to defaultSpan before to.
+func parseDateRange(c *gin.Context, defaultSpan time.Duration) (time.Time, time.Time, error) {
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if s := c.Query("to"); s != "" {
+		t, err := time.Parse(dateLayout, s)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to must be a date in YYYY-MM-DD format")
+		}
+		to = t
+	}
+
+	from := to.Add(-defaultSpan)
+	if s := c.Query("from"); s != "" {
+		t, err := time.Parse(dateLayout, s)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("from must be a date in YYYY-MM-DD format")
+		}
+		from = t
+	}
+
+	if from.After(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must not be after to")
+	}
+	return from, to, nil
+}
+
+func registerHistoryRoutes(protected *gin.RouterGroup) {
+	protected.GET("/history", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+
+		from, to, err := parseDateRange(c, 365*24*time.Hour)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		interval := c.DefaultQuery("interval", "day")
+		if _, ok := historyIntervals[interval]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be one of day, week or month"})
+			return
+		}
+
+		ticker, err := normalizeOptionalSymbol(c.Query("ticker"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		portfolioID := c.Query("portfolio_id")
+
+		points, conv, err := getPortfolioHistory(userID, from, to, interval, ticker, portfolioID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, PortfolioHistory{
+			From:         from.Format(dateLayout),
+			To:           to.Format(dateLayout),
+			Interval:     interval,
+			Ticker:       ticker,
+			PortfolioID:  portfolioID,
+			BaseCurrency: conv.base,
+			Rates:        conv.used,
+			Points:       points,
+		})
+	})
+}
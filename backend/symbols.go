@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errNoQuoteData   = errors.New("no quote data found")
+	errUnknownSymbol = errors.New("unknown symbol")
+)
+
+const (
+	defaultSymbolSearchLimit = 10
+	maxSymbolSearchLimit     = 50
+	maxSymbolSuggestions     = 5
+	maxSymbolLength          = 10
+)
+
+// exchangeSuffixes maps the exchange codes users type (as in "TSX:RY",
+// "RY:TSX" or "RY.TSX") to the suffix FMP uses for the listing. FMP's own
+// suffixes map to themselves, and US exchanges take no suffix.
+var exchangeSuffixes = map[string]string{
+	"NYSE": "", "NASDAQ": "", "AMEX": "", "NYSEARCA": "", "ARCA": "", "BATS": "", "US": "",
+	"TO": "TO", "TSX": "TO", "TSE": "TO",
+	"V": "V", "TSXV": "V", "CVE": "V",
+	"CN": "CN", "CSE": "CN",
+	"NE": "NE", "NEO": "NE",
+	"L": "L", "LSE": "L", "LON": "L",
+	"AX": "AX", "ASX": "AX",
+	"NZ": "NZ", "NZX": "NZ",
+	"DE": "DE", "XETRA": "DE", "ETR": "DE",
+	"F": "F", "FRA": "F",
+	"PA": "PA", "EPA": "PA", "PAR": "PA",
+	"AS": "AS", "AMS": "AS",
+	"BR": "BR", "EBR": "BR", "BRU": "BR",
+	"MI": "MI", "BIT": "MI", "MIL": "MI",
+	"MC": "MC", "BME": "MC",
+	"SW": "SW", "SIX": "SW", "SWX": "SW",
+	"ST": "ST", "STO": "ST",
+	"OL": "OL", "OSL": "OL",
+	"CO": "CO", "CPH": "CO",
+	"HE": "HE", "HEL": "HE",
+	"HK": "HK", "HKEX": "HK", "HKG": "HK",
+	"T": "T", "TYO": "T",
+	"SI": "SI", "SGX": "SI",
+	"NS": "NS", "NSE": "NS",
+	"BO": "BO", "BSE": "BO",
+	"KS": "KS", "KRX": "KS",
+	"JO": "JO", "JSE": "JO",
+}
+
+// SymbolMatch is a security returned by the provider's symbol search.
+type SymbolMatch struct {
+	Symbol            string `json:"symbol"`
+	Name              string `json:"name"`
+	Currency          string `json:"currency"`
+	StockExchange     string `json:"stock_exchange"`
+	ExchangeShortName string `json:"exchange_short_name"`
+}
+
+type SymbolSearchResult struct {
+	Query   string        `json:"query"`
+	Results []SymbolMatch `json:"results"`
+}
+
+func searchFMPSymbols(query, apiKey string, limit int) ([]SymbolMatch, error) {
+	endpoint := fmt.Sprintf("https://financialmodelingprep.com/api/v3/search?query=%s&limit=%d&apikey=%s", url.QueryEscape(query), limit, apiKey)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search symbols from FMP: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("FMP search API returned status %d", resp.StatusCode)
+	}
+
+	var fmpResp []struct {
+		Symbol            string `json:"symbol"`
+		Name              string `json:"name"`
+		Currency          string `json:"currency"`
+		StockExchange     string `json:"stockExchange"`
+		ExchangeShortName string `json:"exchangeShortName"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&fmpResp); err != nil {
+		return nil, fmt.Errorf("failed to parse FMP search response: %v", err)
+	}
+
+	matches := make([]SymbolMatch, 0, len(fmpResp))
+	for _, m := range fmpResp {
+		matches = append(matches, SymbolMatch{
+			Symbol:            m.Symbol,
+			Name:              m.Name,
+			Currency:          m.Currency,
+			StockExchange:     m.StockExchange,
+			ExchangeShortName: m.ExchangeShortName,
+		})
+	}
+	return matches, nil
+}
+
+// normalizeSymbol turns a ticker as typed into the provider's form: upper
+// case, an exchange prefix or suffix resolved to FMP's listing suffix
+// ("TSX:RY" and "RY.TSX" become "RY.TO"), and share classes joined with a
+// dash ("brk.b" and "BRK/B" become "BRK-B").
+func normalizeSymbol(input string) (string, error) {
+	s := strings.ToUpper(strings.TrimSpace(input))
+	if s == "" {
+		return "", fmt.Errorf("symbol is required")
+	}
+
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		left, right := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+		if _, ok := exchangeSuffixes[left]; ok {
+			s = right + "." + left
+		} else {
+			s = left + "." + right
+		}
+	}
+	s = strings.NewReplacer("/", ".", " ", ".").Replace(s)
+
+	parts := strings.Split(s, ".")
+	suffix := ""
+	if len(parts) > 1 {
+		if sfx, ok := exchangeSuffixes[parts[len(parts)-1]]; ok {
+			suffix = sfx
+			parts = parts[:len(parts)-1]
+		}
+	}
+	for _, p := range parts {
+		if p == "" {
+			return "", fmt.Errorf("invalid symbol %q", input)
+		}
+	}
+
+	symbol := strings.Join(parts, "-")
+	if suffix != "" {
+		symbol += "." + suffix
+	}
+	if len(symbol) > maxSymbolLength {
+		return "", fmt.Errorf("symbol %q is longer than %d characters", input, maxSymbolLength)
+	}
+	for _, r := range symbol {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '^' || r == '=') {
+			return "", fmt.Errorf("invalid symbol %q", input)
+		}
+	}
+	return symbol, nil
+}
+
+// normalizeOptionalSymbol normalizes a ticker filter, leaving an empty one
+// empty.
+func normalizeOptionalSymbol(input string) (string, error) {
+	if strings.TrimSpace(input) == "" {
+		return "", nil
+	}
+	return normalizeSymbol(input)
+}
+
+// resolveSymbol normalizes a ticker and checks the provider can quote it.
+// For an unknown symbol it returns errUnknownSymbol along with the closest
+// search matches; a failed search just leaves the suggestions empty.
+func resolveSymbol(input, apiKey string) (string, []SymbolMatch, error) {
+	symbol, err := normalizeSymbol(input)
+	if err != nil {
+		return "", nil, err
+	}
+
+	_, _, err = fetchFMPQuote(symbol, apiKey)
+	if err == nil {
+		return symbol, nil, nil
+	}
+	if !errors.Is(err, errNoQuoteData) {
+		return symbol, nil, err
+	}
+
+	// Search on the ticker without its exchange suffix, so a listing on
+	// another exchange is suggested too
+	query := symbol
+	if i := strings.IndexByte(query, '.'); i > 0 {
+		query = query[:i]
+	}
+	suggestions, searchErr := searchFMPSymbols(query, apiKey, maxSymbolSuggestions)
+	if searchErr != nil {
+		fmt.Printf("Warning: failed to find suggestions for %s: %v\n", symbol, searchErr)
+		suggestions = []SymbolMatch{}
+	}
+	return symbol, suggestions, errUnknownSymbol
+}
+
+// validateTicker resolves the ticker of a create request, writing a 400 for
+// a malformed symbol, a 422 with suggestions for an unknown one, or a 500
+// when the provider could not be reached. ok is false if a response was
+// written.
+func validateTicker(c *gin.Context, ticker string, apiKey string) (string, bool) {
+	symbol, suggestions, err := resolveSymbol(ticker, apiKey)
+	switch {
+	case err == nil:
+		return symbol, true
+	case err == errUnknownSymbol:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":       fmt.Sprintf("Unknown symbol %s", symbol),
+			"suggestions": suggestions,
+		})
+	case symbol == "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+	return "", false
+}
+
+func registerSymbolRoutes(r *gin.Engine, apiKey string) {
+	r.GET("/symbols/search", func(c *gin.Context) {
+		query := strings.TrimSpace(c.Query("q"))
+		if query == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+			return
+		}
+
+		limit := defaultSymbolSearchLimit
+		if raw := c.Query("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxSymbolSearchLimit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxSymbolSearchLimit)})
+				return
+			}
+			limit = n
+		}
+
+		matches, err := searchFMPSymbols(query, apiKey, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, SymbolSearchResult{Query: query, Results: matches})
+	})
+}
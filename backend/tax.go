@@ -0,0 +1,664 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tax classifications of a holding's dividends. Qualified dividends are
+// only qualified for shares that pass the holding-period test; ordinary
+// covers REITs, MLPs, money market and bond funds; return_of_capital
+// distributions are not income but reduce cost basis.
+const (
+	TaxQualified       = "qualified"
+	TaxOrdinary        = "ordinary"
+	TaxReturnOfCapital = "return_of_capital"
+)
+
+// Holding-period test outcomes, worst last.
+const (
+	HoldingPeriodMet        = "met"
+	HoldingPeriodUnverified = "unverified"
+	HoldingPeriodPartial    = "partial"
+	HoldingPeriodNotMet     = "not_met"
+)
+
+var holdingPeriodRank = map[string]int{
+	"": 0, HoldingPeriodMet: 1, HoldingPeriodUnverified: 2, HoldingPeriodPartial: 3, HoldingPeriodNotMet: 4,
+}
+
+// Qualified dividends require shares held more than qualifiedHoldingDays
+// days of the window starting qualifiedWindowDays before the ex-date and
+// ending qualifiedWindowDays after it (121 days in all).
+const (
+	qualifiedHoldingDays = 60
+	qualifiedWindowDays  = 60
+)
+
+// maxPaymentLagDays bounds how long after an ex-date a payment is
+// matched to it when the provider has no payment date.
+const maxPaymentLagDays = 90
+
+// taxAdvantagedAccounts are portfolio account types whose dividends are
+// not taxed when received.
+var taxAdvantagedAccounts = map[string]bool{
+	"ira": true, "roth_ira": true, "401k": true, "roth_401k": true, "hsa": true,
+}
+
+// TaxClassification sets how a ticker's dividends are taxed. Unclassified
+// tickers are qualified, or ordinary for Real Estate sector holdings.
+type TaxClassification struct {
+	Ticker         string `json:"ticker" binding:"required"`
+	Classification string `json:"classification" binding:"required,oneof=qualified ordinary return_of_capital"`
+}
+
+type TaxClassificationsRequest struct {
+	Classifications []TaxClassification `json:"classifications" binding:"dive"`
+}
+
+type TaxTotals struct {
+	Total           float64 `json:"total"`
+	Qualified       float64 `json:"qualified"`
+	Ordinary        float64 `json:"ordinary"`
+	ReturnOfCapital float64 `json:"return_of_capital"`
+}
+
+func (t *TaxTotals) add(o TaxTotals) {
+	t.Total += o.Total
+	t.Qualified += o.Qualified
+	t.Ordinary += o.Ordinary
+	t.ReturnOfCapital += o.ReturnOfCapital
+}
+
+func (t *TaxTotals) round() {
+	t.Total, t.Qualified = roundTo(t.Total, 2), roundTo(t.Qualified, 2)
+	t.Ordinary, t.ReturnOfCapital = roundTo(t.Ordinary, 2), roundTo(t.ReturnOfCapital, 2)
+}
+
+// TaxPayment is one dividend received. ExDate is empty when no ex-date
+// could be matched, in which case the holding period is unverified.
+type TaxPayment struct {
+	Date          string `json:"date"`
+	ExDate        string `json:"ex_date,omitempty"`
+	HoldingPeriod string `json:"holding_period,omitempty"`
+	TaxTotals
+}
+
+// TaxHolding is a ticker's dividends in one account. HoldingPeriod is the
+// worst outcome across its payments, and empty unless it is classified
+// qualified.
+type TaxHolding struct {
+	PortfolioID          string `json:"portfolio_id"`
+	PortfolioName        string `json:"portfolio_name"`
+	AccountType          string `json:"account_type"`
+	TaxAdvantaged        bool   `json:"tax_advantaged"`
+	Ticker               string `json:"ticker"`
+	Classification       string `json:"classification"`
+	ClassificationSource string `json:"classification_source"`
+	ReturnOfCapitalFlag  bool   `json:"return_of_capital_flag"`
+	HoldingPeriod        string `json:"holding_period,omitempty"`
+	TaxTotals
+	Payments []TaxPayment `json:"payments"`
+}
+
+type TaxAccount struct {
+	PortfolioID   string `json:"portfolio_id"`
+	Name          string `json:"name"`
+	AccountType   string `json:"account_type"`
+	TaxAdvantaged bool   `json:"tax_advantaged"`
+	TaxTotals
+}
+
+// TaxReport totals the dividends recorded in the transaction ledger for a
+// calendar year, in the base currency. Totals covers taxable accounts
+// only; dividends in tax-advantaged accounts are reported separately.
+type TaxReport struct {
+	Year               int          `json:"year"`
+	PortfolioID        string       `json:"portfolio_id,omitempty"`
+	BaseCurrency       string       `json:"base_currency"`
+	Rates              []FXRate     `json:"rates"`
+	Totals             TaxTotals    `json:"totals"`
+	TaxAdvantagedTotal float64      `json:"tax_advantaged_total"`
+	Accounts           []TaxAccount `json:"accounts"`
+	Holdings           []TaxHolding `json:"holdings"`
+	Warnings           []string     `json:"warnings"`
+}
+
+func getTaxClassifications(userID string) ([]TaxClassification, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot load tax classifications")
+	}
+
+	rows, err := db.Query(`
+		SELECT ticker, classification FROM tax_classifications
+		WHERE user_id = $1
+		ORDER BY ticker
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tax classifications: %v", err)
+	}
+	defer rows.Close()
+
+	classifications := []TaxClassification{}
+	for rows.Next() {
+		var c TaxClassification
+		if err := rows.Scan(&c.Ticker, &c.Classification); err != nil {
+			return nil, fmt.Errorf("failed to scan tax classification: %v", err)
+		}
+		classifications = append(classifications, c)
+	}
+	return classifications, rows.Err()
+}
+
+// replaceTaxClassifications swaps the user's classifications for the
+// given set.
+func replaceTaxClassifications(userID string, classifications []TaxClassification) error {
+	if db == nil {
+		return fmt.Errorf("database unavailable - cannot save tax classifications")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin classifications transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM tax_classifications WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to clear tax classifications: %v", err)
+	}
+	for _, c := range classifications {
+		_, err := tx.Exec(`
+			INSERT INTO tax_classifications (user_id, ticker, classification, updated_at)
+			VALUES ($1, $2, $3, NOW())
+		`, userID, c.Ticker, c.Classification)
+		if err != nil {
+			return fmt.Errorf("failed to insert tax classification for %s: %v", c.Ticker, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// taxLot is shares bought together; disposed is the zero time while the
+// shares are still held.
+type taxLot struct {
+	acquired time.Time
+	shares   float64
+	disposed time.Time
+}
+
+// buildTaxLots replays a ticker's buys and sells in one account, selling
+// the oldest shares first. Transactions must be sorted by trade date.
+func buildTaxLots(transactions []Transaction) []taxLot {
+	var lots []taxLot
+	for _, t := range transactions {
+		date, err := time.Parse(dateLayout, t.TradeDate)
+		if err != nil {
+			continue
+		}
+		switch t.Type {
+		case TransactionBuy:
+			lots = append(lots, taxLot{acquired: date, shares: t.Shares})
+		case TransactionSell:
+			remaining := t.Shares
+			for i := 0; i < len(lots) && remaining > 0; i++ {
+				if !lots[i].disposed.IsZero() {
+					continue
+				}
+				if lots[i].shares > remaining {
+					// Split the lot; the unsold part stays open, next in line
+					rest := taxLot{acquired: lots[i].acquired, shares: lots[i].shares - remaining}
+					lots = append(lots[:i+1], append([]taxLot{rest}, lots[i+1:]...)...)
+					lots[i].shares = remaining
+				}
+				lots[i].disposed = date
+				remaining -= lots[i].shares
+			}
+		}
+	}
+	return lots
+}
+
+// holdingPeriod returns the shares entitled to a dividend with the given
+// ex-date (held before it and not sold before it) and how many of those
+// pass the holding-period test. The holding period starts the day after
+// purchase and includes the day of sale; shares still held are assumed
+// to be held through the end of the window.
+func holdingPeriod(lots []taxLot, exDate time.Time) (entitled, qualified float64) {
+	windowStart := exDate.AddDate(0, 0, -qualifiedWindowDays)
+	windowEnd := exDate.AddDate(0, 0, qualifiedWindowDays)
+	for _, lot := range lots {
+		if !lot.acquired.Before(exDate) || (!lot.disposed.IsZero() && lot.disposed.Before(exDate)) {
+			continue
+		}
+		entitled += lot.shares
+
+		from := lot.acquired.AddDate(0, 0, 1)
+		if from.Before(windowStart) {
+			from = windowStart
+		}
+		to := windowEnd
+		if !lot.disposed.IsZero() && lot.disposed.Before(to) {
+			to = lot.disposed
+		}
+		if days := int(to.Sub(from).Hours()/24) + 1; days > qualifiedHoldingDays {
+			qualified += lot.shares
+		}
+	}
+	return entitled, qualified
+}
+
+// matchExDate finds the ex-date of a dividend paid on paid: the event
+// with that payment date, or else the latest ex-date up to
+// maxPaymentLagDays before it.
+func matchExDate(events []DividendEvent, paid time.Time) (time.Time, bool) {
+	var best time.Time
+	for _, e := range events {
+		exDate, err := time.Parse(dateLayout, e.ExDate)
+		if err != nil {
+			continue
+		}
+		if e.PaymentDate == paid.Format(dateLayout) {
+			return exDate, true
+		}
+		if !exDate.After(paid) && paid.Sub(exDate).Hours()/24 <= maxPaymentLagDays && exDate.After(best) {
+			best = exDate
+		}
+	}
+	return best, !best.IsZero()
+}
+
+// splitPayment divides a dividend according to the holding's
+// classification and, for qualified holdings, the holding-period test.
+// Without an ex-date or ledger buys covering it, the test cannot be run
+// and the dividend counts as qualified, flagged unverified.
+func splitPayment(amount float64, classification string, lots []taxLot, exDate time.Time, matched bool) TaxPayment {
+	p := TaxPayment{TaxTotals: TaxTotals{Total: amount}}
+	if matched {
+		p.ExDate = exDate.Format(dateLayout)
+	}
+	switch classification {
+	case TaxOrdinary:
+		p.Ordinary = amount
+		return p
+	case TaxReturnOfCapital:
+		p.ReturnOfCapital = amount
+		return p
+	}
+
+	var entitled, qualified float64
+	if matched {
+		entitled, qualified = holdingPeriod(lots, exDate)
+	}
+	switch {
+	case entitled == 0:
+		p.HoldingPeriod = HoldingPeriodUnverified
+		p.Qualified = amount
+	case qualified >= entitled:
+		p.HoldingPeriod = HoldingPeriodMet
+		p.Qualified = amount
+	case qualified == 0:
+		p.HoldingPeriod = HoldingPeriodNotMet
+		p.Ordinary = amount
+	default:
+		p.HoldingPeriod = HoldingPeriodPartial
+		p.Qualified = amount * qualified / entitled
+		p.Ordinary = amount - p.Qualified
+	}
+	return p
+}
+
+func getTaxReport(userID, portfolioID string, year int, apiKey string) (*TaxReport, error) {
+	report := &TaxReport{
+		Year:        year,
+		PortfolioID: portfolioID,
+		Accounts:    []TaxAccount{},
+		Holdings:    []TaxHolding{},
+		Warnings:    []string{},
+	}
+
+	portfolios, err := getPortfolios(userID)
+	if err != nil {
+		return nil, err
+	}
+	accounts := map[string]Portfolio{}
+	for _, p := range portfolios {
+		accounts[p.ID] = p
+	}
+
+	classified, err := getTaxClassifications(userID)
+	if err != nil {
+		return nil, err
+	}
+	classifications := map[string]string{}
+	for _, c := range classified {
+		classifications[c.Ticker] = c.Classification
+	}
+
+	holdings, err := getHoldings(userID)
+	if err != nil {
+		return nil, err
+	}
+	sectors := map[string]string{}
+	for _, h := range holdings {
+		sectors[h.Ticker] = h.Sector
+	}
+
+	transactions, err := getTransactions(userID, TransactionFilter{PortfolioID: portfolioID})
+	if err != nil {
+		return nil, err
+	}
+	lfx, err := newLedgerFX(userID, nil, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if transactions, err = lfx.convertTransactions(transactions); err != nil {
+		return nil, err
+	}
+	report.BaseCurrency, report.Rates = lfx.conv.base, lfx.conv.used
+	sort.SliceStable(transactions, func(i, j int) bool { return transactions[i].TradeDate < transactions[j].TradeDate })
+
+	type holdingKey struct{ portfolioID, ticker string }
+	ledgers := map[holdingKey][]Transaction{}
+	var keys []holdingKey
+	for _, t := range transactions {
+		k := holdingKey{t.PortfolioID, t.Ticker}
+		if _, ok := ledgers[k]; !ok {
+			keys = append(keys, k)
+		}
+		ledgers[k] = append(ledgers[k], t)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := accounts[keys[i].portfolioID].Name, accounts[keys[j].portfolioID].Name
+		if a != b {
+			return a < b
+		}
+		return keys[i].ticker < keys[j].ticker
+	})
+
+	events := map[string][]DividendEvent{}
+	accountTotals := map[string]*TaxAccount{}
+	for _, k := range keys {
+		var paid []Transaction
+		for _, t := range ledgers[k] {
+			if t.Type == TransactionDividend && strings.HasPrefix(t.TradeDate, strconv.Itoa(year)+"-") {
+				paid = append(paid, t)
+			}
+		}
+		if len(paid) == 0 {
+			continue
+		}
+
+		account := accounts[k.portfolioID]
+		h := TaxHolding{
+			PortfolioID:          k.portfolioID,
+			PortfolioName:        account.Name,
+			AccountType:          account.AccountType,
+			TaxAdvantaged:        taxAdvantagedAccounts[account.AccountType],
+			Ticker:               k.ticker,
+			Classification:       classifications[k.ticker],
+			ClassificationSource: "user",
+			Payments:             []TaxPayment{},
+		}
+		if h.Classification == "" {
+			h.Classification, h.ClassificationSource = TaxQualified, "default"
+			if sectors[k.ticker] == "Real Estate" {
+				h.Classification, h.ClassificationSource = TaxOrdinary, "sector"
+			}
+		}
+		h.ReturnOfCapitalFlag = h.Classification == TaxReturnOfCapital
+
+		if _, ok := events[k.ticker]; !ok && h.Classification == TaxQualified {
+			e, err := getDividendHistory(k.ticker, apiKey)
+			if err != nil {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("no dividend history for %s, holding period unverified: %v", k.ticker, err))
+			}
+			events[k.ticker] = e
+		}
+
+		lots := buildTaxLots(ledgers[k])
+		for _, t := range paid {
+			date, _ := time.Parse(dateLayout, t.TradeDate)
+			exDate, matched := matchExDate(events[k.ticker], date)
+			p := splitPayment(t.Amount, h.Classification, lots, exDate, matched)
+			p.Date = t.TradeDate
+			if holdingPeriodRank[p.HoldingPeriod] > holdingPeriodRank[h.HoldingPeriod] {
+				h.HoldingPeriod = p.HoldingPeriod
+			}
+			h.TaxTotals.add(p.TaxTotals)
+			p.TaxTotals.round()
+			h.Payments = append(h.Payments, p)
+		}
+
+		a, ok := accountTotals[k.portfolioID]
+		if !ok {
+			a = &TaxAccount{PortfolioID: k.portfolioID, Name: account.Name, AccountType: account.AccountType, TaxAdvantaged: h.TaxAdvantaged}
+			accountTotals[k.portfolioID] = a
+		}
+		a.TaxTotals.add(h.TaxTotals)
+		if h.TaxAdvantaged {
+			report.TaxAdvantagedTotal += h.Total
+		} else {
+			report.Totals.add(h.TaxTotals)
+		}
+		h.TaxTotals.round()
+		report.Holdings = append(report.Holdings, h)
+	}
+
+	for _, h := range report.Holdings {
+		if a, ok := accountTotals[h.PortfolioID]; ok {
+			a.TaxTotals.round()
+			report.Accounts = append(report.Accounts, *a)
+			delete(accountTotals, h.PortfolioID)
+		}
+	}
+	report.Totals.round()
+	report.TaxAdvantagedTotal = roundTo(report.TaxAdvantagedTotal, 2)
+
+	return report, nil
+}
+
+// Tax report CSV tables, in column order.
+var (
+	taxHoldingColumns = []string{
+		"year", "portfolio_id", "portfolio_name", "account_type", "tax_advantaged", "ticker", "classification",
+		"classification_source", "total", "qualified", "ordinary", "return_of_capital", "return_of_capital_flag", "holding_period",
+	}
+	taxAccountColumns = []string{
+		"year", "portfolio_id", "name", "account_type", "tax_advantaged", "total", "qualified", "ordinary", "return_of_capital",
+	}
+	taxPaymentColumns = []string{
+		"year", "portfolio_id", "portfolio_name", "ticker", "date", "ex_date", "classification", "holding_period",
+		"total", "qualified", "ordinary", "return_of_capital",
+	}
+)
+
+func formatTaxAmount(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+func (r *TaxReport) csvRows(table string) [][]string {
+	year := strconv.Itoa(r.Year)
+	amounts := func(t TaxTotals) []string {
+		return []string{formatTaxAmount(t.Total), formatTaxAmount(t.Qualified), formatTaxAmount(t.Ordinary), formatTaxAmount(t.ReturnOfCapital)}
+	}
+
+	var rows [][]string
+	switch table {
+	case "accounts":
+		rows = append(rows, taxAccountColumns)
+		for _, a := range r.Accounts {
+			row := []string{year, a.PortfolioID, a.Name, a.AccountType, strconv.FormatBool(a.TaxAdvantaged)}
+			rows = append(rows, append(row, amounts(a.TaxTotals)...))
+		}
+	case "payments":
+		rows = append(rows, taxPaymentColumns)
+		for _, h := range r.Holdings {
+			for _, p := range h.Payments {
+				row := []string{year, h.PortfolioID, h.PortfolioName, h.Ticker, p.Date, p.ExDate, h.Classification, p.HoldingPeriod}
+				rows = append(rows, append(row, amounts(p.TaxTotals)...))
+			}
+		}
+	default:
+		rows = append(rows, taxHoldingColumns)
+		for _, h := range r.Holdings {
+			row := []string{year, h.PortfolioID, h.PortfolioName, h.AccountType, strconv.FormatBool(h.TaxAdvantaged), h.Ticker, h.Classification, h.ClassificationSource}
+			row = append(row, amounts(h.TaxTotals)...)
+			rows = append(rows, append(row, strconv.FormatBool(h.ReturnOfCapitalFlag), h.HoldingPeriod))
+		}
+	}
+	return rows
+}
+
+// pdfLines lays the report out as fixed-width text for writePDF.
+func (r *TaxReport) pdfLines() []string {
+	const row = "%-28s %12s %12s %12s %12s"
+	amounts := func(label string, t TaxTotals) string {
+		return fmt.Sprintf(row, label, formatTaxAmount(t.Total), formatTaxAmount(t.Qualified), formatTaxAmount(t.Ordinary), formatTaxAmount(t.ReturnOfCapital))
+	}
+	header := fmt.Sprintf(row, "", "Total", "Qualified", "Ordinary", "Return of cap")
+
+	lines := []string{
+		fmt.Sprintf("Dividend Tax Report - %d", r.Year),
+		fmt.Sprintf("Generated %s from the transaction ledger, in %s", time.Now().Format(dateLayout), r.BaseCurrency),
+		"",
+		header,
+		amounts("Taxable accounts", r.Totals),
+		fmt.Sprintf("%-28s %12s", "Tax-advantaged accounts", formatTaxAmount(r.TaxAdvantagedTotal)),
+		"",
+		"By account",
+		header,
+	}
+	for _, a := range r.Accounts {
+		label := a.Name
+		if a.TaxAdvantaged {
+			label += " *"
+		}
+		lines = append(lines, amounts(label, a.TaxTotals))
+	}
+
+	lines = append(lines, "", "By holding", header)
+	for _, h := range r.Holdings {
+		label := h.Ticker + " (" + h.PortfolioName + ")"
+		if len(label) > 28 {
+			label = label[:28]
+		}
+		lines = append(lines, amounts(label, h.TaxTotals))
+		notes := "  " + h.Classification + " (" + h.ClassificationSource + ")"
+		if h.HoldingPeriod != "" {
+			notes += ", holding period " + strings.ReplaceAll(h.HoldingPeriod, "_", " ")
+		}
+		if h.ReturnOfCapitalFlag {
+			notes += ", RETURN OF CAPITAL"
+		}
+		lines = append(lines, notes)
+	}
+
+	lines = append(lines, "", "* tax-advantaged account, not included in taxable totals")
+	for _, w := range r.Warnings {
+		lines = append(lines, "Warning: "+w)
+	}
+	lines = append(lines, "", "Estimates only. Check against the Form 1099-DIV from each broker.")
+	return lines
+}
+
+func registerReportRoutes(r *gin.Engine, apiKey string) {
+	reports := r.Group("/reports")
+	reports.Use(authMiddleware())
+
+	reports.GET("/tax", func(c *gin.Context) {
+		year := time.Now().Year() - 1
+		if s := c.Query("year"); s != "" {
+			y, err := strconv.Atoi(s)
+			if err != nil || y < 1900 || y > time.Now().Year() {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "year must be a past or current calendar year"})
+				return
+			}
+			year = y
+		}
+		format := strings.ToLower(c.DefaultQuery("format", "json"))
+		table := strings.ToLower(c.DefaultQuery("table", "holdings"))
+		if format != "json" && format != "csv" && format != "pdf" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json, csv or pdf"})
+			return
+		}
+		if table != "holdings" && table != "accounts" && table != "payments" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "table must be holdings, accounts or payments"})
+			return
+		}
+
+		report, err := getTaxReport(c.GetString("user_id"), c.Query("portfolio_id"), year, apiKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		filename := fmt.Sprintf("dividend-tax-report-%d", year)
+		switch format {
+		case "csv":
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.csv"`, filename, table))
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			c.Status(http.StatusOK)
+			w := csv.NewWriter(c.Writer)
+			w.WriteAll(report.csvRows(table))
+		case "pdf":
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, filename))
+			c.Header("Content-Type", "application/pdf")
+			c.Status(http.StatusOK)
+			if err := writePDF(c.Writer, report.pdfLines()); err != nil {
+				c.Error(err)
+			}
+		default:
+			c.JSON(http.StatusOK, report)
+		}
+	})
+
+	reports.GET("/tax/classifications", func(c *gin.Context) {
+		classifications, err := getTaxClassifications(c.GetString("user_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, classifications)
+	})
+
+	reports.PUT("/tax/classifications", func(c *gin.Context) {
+		var req TaxClassificationsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		classifications := req.Classifications
+		if classifications == nil {
+			classifications = []TaxClassification{}
+		}
+		seen := map[string]bool{}
+		for i := range classifications {
+			t, err := normalizeSymbol(classifications[i].Ticker)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if seen[t] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "duplicate classification for " + t})
+				return
+			}
+			seen[t] = true
+			classifications[i].Ticker = t
+		}
+
+		userID := c.GetString("user_id")
+		if err := replaceTaxClassifications(userID, classifications); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, classifications)
+	})
+}
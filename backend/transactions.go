@@ -0,0 +1,311 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errTransactionNotFound = errors.New("transaction not found")
+
+// Transaction types. Amount is always the positive cash value of the
+// transaction: money paid for a buy (including fees), money received for a
+// sell (net of fees) or a dividend.
+const (
+	TransactionBuy      = "buy"
+	TransactionSell     = "sell"
+	TransactionDividend = "dividend"
+)
+
+// Transaction is one entry of the user's trade and dividend ledger. The
+// ledger is independent of portfolio_holdings, which only carries current
+// positions.
+type Transaction struct {
+	ID          string    `json:"id" db:"id"`
+	PortfolioID string    `json:"portfolio_id" db:"portfolio_id"`
+	Ticker      string    `json:"ticker" db:"ticker"`
+	Type        string    `json:"type" db:"type"`
+	TradeDate   string    `json:"trade_date" db:"trade_date"`
+	Shares      float64   `json:"shares" db:"shares"`
+	Price       float64   `json:"price" db:"price"`
+	Fees        float64   `json:"fees" db:"fees"`
+	Amount      float64   `json:"amount" db:"amount"`
+	Notes       string    `json:"notes" db:"notes"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+type TransactionRequest struct {
+	PortfolioID string  `json:"portfolio_id"`
+	Ticker      string  `json:"ticker" binding:"required"`
+	Type        string  `json:"type" binding:"required,oneof=buy sell dividend"`
+	TradeDate   string  `json:"trade_date" binding:"required"`
+	Shares      float64 `json:"shares" binding:"min=0"`
+	Price       float64 `json:"price" binding:"min=0"`
+	Fees        float64 `json:"fees" binding:"min=0"`
+	Amount      float64 `json:"amount" binding:"min=0"`
+	Notes       string  `json:"notes"`
+}
+
+// TransactionFilter narrows getTransactions; zero values match everything.
+type TransactionFilter struct {
+	PortfolioID string
+	Ticker      string
+	From        time.Time
+	To          time.Time
+}
+
+// normalize validates a request and fills in Amount for trades when the
+// caller left it out.
+func (req *TransactionRequest) normalize() error {
+	ticker, err := normalizeSymbol(req.Ticker)
+	if err != nil {
+		return err
+	}
+	req.Ticker = ticker
+	if _, err := time.Parse(dateLayout, req.TradeDate); err != nil {
+		return fmt.Errorf("trade_date must be a date in YYYY-MM-DD format")
+	}
+
+	switch req.Type {
+	case TransactionBuy, TransactionSell:
+		if req.Shares <= 0 || req.Price <= 0 {
+			return fmt.Errorf("%s transactions require positive shares and price", req.Type)
+		}
+		if req.Amount == 0 {
+			req.Amount = req.Shares * req.Price
+			if req.Type == TransactionBuy {
+				req.Amount += req.Fees
+			} else {
+				req.Amount -= req.Fees
+			}
+		}
+	case TransactionDividend:
+		if req.Amount <= 0 {
+			return fmt.Errorf("dividend transactions require a positive amount")
+		}
+	}
+	return nil
+}
+
+const transactionColumns = `id, portfolio_id, ticker, type, trade_date, shares, price, fees, amount, notes, created_at`
+
+func scanTransaction(row rowScanner) (Transaction, error) {
+	var t Transaction
+	var tradeDate time.Time
+	err := row.Scan(&t.ID, &t.PortfolioID, &t.Ticker, &t.Type, &tradeDate, &t.Shares, &t.Price, &t.Fees, &t.Amount, &t.Notes, &t.CreatedAt)
+	t.TradeDate = tradeDate.Format(dateLayout)
+	return t, err
+}
+
+// getTransactions returns the user's ledger ordered by trade date.
+func getTransactions(userID string, filter TransactionFilter) ([]Transaction, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot load transactions")
+	}
+
+	query := `SELECT ` + transactionColumns + ` FROM transactions WHERE user_id = $1`
+	args := []interface{}{userID}
+	if filter.PortfolioID != "" {
+		args = append(args, filter.PortfolioID)
+		query += fmt.Sprintf(" AND portfolio_id = $%d", len(args))
+	}
+	if filter.Ticker != "" {
+		args = append(args, filter.Ticker)
+		query += fmt.Sprintf(" AND ticker = $%d", len(args))
+	}
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		query += fmt.Sprintf(" AND trade_date >= $%d", len(args))
+	}
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		query += fmt.Sprintf(" AND trade_date <= $%d", len(args))
+	}
+	query += " ORDER BY trade_date, created_at"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %v", err)
+	}
+	defer rows.Close()
+
+	transactions := []Transaction{}
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		transactions = append(transactions, t)
+	}
+
+	return transactions, rows.Err()
+}
+
+func createTransaction(req TransactionRequest, userID string) (*Transaction, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot create transactions")
+	}
+
+	portfolioID, err := resolvePortfolioID(req.PortfolioID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO transactions (portfolio_id, ticker, type, trade_date, shares, price, fees, amount, notes, user_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW())
+		RETURNING ` + transactionColumns + `
+	`
+
+	t, err := scanTransaction(db.QueryRow(query,
+		portfolioID, req.Ticker, req.Type, req.TradeDate, req.Shares, req.Price, req.Fees, req.Amount, req.Notes, userID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert transaction: %v", err)
+	}
+	return &t, nil
+}
+
+func updateTransaction(id string, req TransactionRequest, userID string) (*Transaction, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot update transactions")
+	}
+
+	portfolioID, err := resolvePortfolioID(req.PortfolioID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE transactions
+		SET portfolio_id = $1, ticker = $2, type = $3, trade_date = $4, shares = $5, price = $6, fees = $7, amount = $8, notes = $9
+		WHERE id = $10 AND user_id = $11
+		RETURNING ` + transactionColumns + `
+	`
+
+	t, err := scanTransaction(db.QueryRow(query,
+		portfolioID, req.Ticker, req.Type, req.TradeDate, req.Shares, req.Price, req.Fees, req.Amount, req.Notes, id, userID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, errTransactionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update transaction: %v", err)
+	}
+	return &t, nil
+}
+
+func deleteTransaction(id string, userID string) error {
+	if db == nil {
+		return fmt.Errorf("database unavailable - cannot delete transactions")
+	}
+
+	result, err := db.Exec("DELETE FROM transactions WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete transaction: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return errTransactionNotFound
+	}
+
+	return nil
+}
+
+// transactionErrorStatus maps ledger errors onto HTTP status codes.
+func transactionErrorStatus(err error) int {
+	switch err {
+	case errTransactionNotFound, errPortfolioNotFound:
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func registerTransactionRoutes(r *gin.Engine) {
+	transactions := r.Group("/transactions")
+	transactions.Use(authMiddleware())
+
+	transactions.GET("", func(c *gin.Context) {
+		ticker, err := normalizeOptionalSymbol(c.Query("ticker"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter := TransactionFilter{
+			PortfolioID: c.Query("portfolio_id"),
+			Ticker:      ticker,
+		}
+		for name, dest := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+			if s := c.Query(name); s != "" {
+				t, err := time.Parse(dateLayout, s)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be a date in YYYY-MM-DD format"})
+					return
+				}
+				*dest = t
+			}
+		}
+
+		list, err := getTransactions(c.GetString("user_id"), filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	transactions.POST("", func(c *gin.Context) {
+		var req TransactionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := req.normalize(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		t, err := createTransaction(req, c.GetString("user_id"))
+		if err != nil {
+			c.JSON(transactionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, t)
+	})
+
+	transactions.PUT("/:id", func(c *gin.Context) {
+		var req TransactionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := req.normalize(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		t, err := updateTransaction(c.Param("id"), req, c.GetString("user_id"))
+		if err != nil {
+			c.JSON(transactionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, t)
+	})
+
+	transactions.DELETE("/:id", func(c *gin.Context) {
+		if err := deleteTransaction(c.Param("id"), c.GetString("user_id")); err != nil {
+			c.JSON(transactionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Transaction deleted successfully"})
+	})
+}
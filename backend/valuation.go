@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Yield valuation signals. A yield high in its own range suggests the
+// price is low relative to the dividend, and the other way round.
+const (
+	ValuationUndervalued      = "undervalued"
+	ValuationFair             = "fair"
+	ValuationOvervalued       = "overvalued"
+	ValuationNoDividend       = "no_dividend"
+	ValuationInsufficientData = "insufficient_data"
+)
+
+// valuationYears is the look-back for the yield range. Signals need at
+// least valuationMinObservations trading days (about a year) with a full
+// trailing year of dividends; the current yield must rank at or above
+// undervaluedPercentile, or at or below overvaluedPercentile, of them.
+const (
+	valuationYears           = 5
+	valuationMinObservations = 250
+	undervaluedPercentile    = 80
+	overvaluedPercentile     = 20
+)
+
+// YieldValuation compares the current trailing twelve-month yield with
+// its daily history. Yields are in percent; Percentile is the share of
+// days with a lower yield, and VsAverage how far the current yield is
+// above (positive) or below the average, in percent of the average.
+type YieldValuation struct {
+	Ticker       string  `json:"ticker"`
+	HoldingID    string  `json:"holding_id,omitempty"`
+	PortfolioID  string  `json:"portfolio_id,omitempty"`
+	WatchlistID  string  `json:"watchlist_id,omitempty"`
+	From         string  `json:"from,omitempty"`
+	To           string  `json:"to,omitempty"`
+	Observations int     `json:"observations"`
+	CurrentYield float64 `json:"current_yield"`
+	AverageYield float64 `json:"average_yield"`
+	MinYield     float64 `json:"min_yield"`
+	MaxYield     float64 `json:"max_yield"`
+	Percentile   float64 `json:"percentile"`
+	VsAverage    float64 `json:"vs_average"`
+	Signal       string  `json:"signal"`
+}
+
+// dailyYields returns each day's trailing twelve-month dividend over that
+// day's close. Days before the first full year of dividend history are
+// skipped so a recent first payment does not read as a low yield.
+func dailyYields(bars []PriceBar, events []DividendEvent) (yields []float64, from, to string) {
+	var exDates []time.Time
+	var amounts []float64
+	for _, e := range events {
+		t, err := time.Parse(dateLayout, e.ExDate)
+		if err != nil {
+			continue
+		}
+		exDates = append(exDates, t)
+		amounts = append(amounts, e.AdjAmount)
+	}
+	if len(exDates) == 0 {
+		return nil, "", ""
+	}
+	firstFullYear := exDates[0].AddDate(1, 0, 0)
+
+	var sum float64
+	lo, hi := 0, 0
+	for _, b := range bars {
+		day, err := time.Parse(dateLayout, b.Date)
+		if err != nil || b.Close <= 0 {
+			continue
+		}
+		for hi < len(exDates) && !exDates[hi].After(day) {
+			sum += amounts[hi]
+			hi++
+		}
+		yearAgo := day.AddDate(-1, 0, 0)
+		for lo < hi && !exDates[lo].After(yearAgo) {
+			sum -= amounts[lo]
+			lo++
+		}
+		if day.Before(firstFullYear) {
+			continue
+		}
+		if from == "" {
+			from = b.Date
+		}
+		to = b.Date
+		yields = append(yields, sum/b.Close*100)
+	}
+	return yields, from, to
+}
+
+// valueByYield ranks the latest daily yield within the series.
+func valueByYield(ticker string, bars []PriceBar, events []DividendEvent) YieldValuation {
+	v := YieldValuation{Ticker: ticker, Signal: ValuationInsufficientData}
+	yields, from, to := dailyYields(bars, events)
+	v.From, v.To, v.Observations = from, to, len(yields)
+	if len(yields) == 0 {
+		return v
+	}
+
+	current := yields[len(yields)-1]
+	lowest, highest, total := yields[0], yields[0], 0.0
+	var below, equal int
+	for _, y := range yields {
+		if y < lowest {
+			lowest = y
+		}
+		if y > highest {
+			highest = y
+		}
+		total += y
+		if y < current {
+			below++
+		} else if y == current {
+			equal++
+		}
+	}
+	average := total / float64(len(yields))
+
+	v.CurrentYield, v.AverageYield = roundTo(current, 2), roundTo(average, 2)
+	v.MinYield, v.MaxYield = roundTo(lowest, 2), roundTo(highest, 2)
+	v.Percentile = roundTo((float64(below)+float64(equal)/2)/float64(len(yields))*100, 1)
+	if average > 0 {
+		v.VsAverage = roundTo((current-average)/average*100, 1)
+	}
+
+	switch {
+	case len(yields) < valuationMinObservations:
+	case current <= 0:
+		v.Signal = ValuationNoDividend
+	case v.Percentile >= undervaluedPercentile:
+		v.Signal = ValuationUndervalued
+	case v.Percentile <= overvaluedPercentile:
+		v.Signal = ValuationOvervalued
+	default:
+		v.Signal = ValuationFair
+	}
+	return v
+}
+
+type ValuationReport struct {
+	Valuations []YieldValuation `json:"valuations"`
+	Warnings   []string         `json:"warnings"`
+}
+
+// yieldValuer values each symbol once over the same window, collecting
+// provider failures as warnings.
+type yieldValuer struct {
+	apiKey     string
+	from, to   time.Time
+	valuations map[string]YieldValuation
+	warnings   []string
+}
+
+func newYieldValuer(apiKey string) *yieldValuer {
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return &yieldValuer{
+		apiKey:     apiKey,
+		from:       to.AddDate(-valuationYears, 0, 0),
+		to:         to,
+		valuations: map[string]YieldValuation{},
+		warnings:   []string{},
+	}
+}
+
+func (v *yieldValuer) value(ticker string) YieldValuation {
+	if valuation, ok := v.valuations[ticker]; ok {
+		return valuation
+	}
+	bars, err := getPriceHistory(ticker, v.from, v.to, v.apiKey)
+	if err != nil {
+		v.warnings = append(v.warnings, fmt.Sprintf("no price history for %s: %v", ticker, err))
+	}
+	events, err := getDividendHistory(ticker, v.apiKey)
+	if err != nil {
+		v.warnings = append(v.warnings, fmt.Sprintf("no dividend history for %s: %v", ticker, err))
+	}
+	valuation := valueByYield(ticker, bars, events)
+	v.valuations[ticker] = valuation
+	return valuation
+}
+
+func getHoldingValuations(userID, portfolioID, apiKey string) (*ValuationReport, error) {
+	holdings, err := getRawScopedHoldings(userID, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	valuer := newYieldValuer(apiKey)
+	report := &ValuationReport{Valuations: []YieldValuation{}}
+	for _, h := range holdings {
+		valuation := valuer.value(h.Ticker)
+		valuation.HoldingID, valuation.PortfolioID = h.ID, h.PortfolioID
+		report.Valuations = append(report.Valuations, valuation)
+	}
+	sort.SliceStable(report.Valuations, func(i, j int) bool { return report.Valuations[i].Ticker < report.Valuations[j].Ticker })
+	report.Warnings = valuer.warnings
+	return report, nil
+}
+
+func getWatchlistValuations(userID, apiKey string) (*ValuationReport, error) {
+	entries, err := getWatchlist(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	valuer := newYieldValuer(apiKey)
+	report := &ValuationReport{Valuations: []YieldValuation{}}
+	for _, w := range entries {
+		valuation := valuer.value(w.Ticker)
+		valuation.WatchlistID = w.ID
+		report.Valuations = append(report.Valuations, valuation)
+	}
+	report.Warnings = valuer.warnings
+	return report, nil
+}
+
+func registerValuationRoutes(protected *gin.RouterGroup, apiKey string) {
+	protected.GET("/valuation", func(c *gin.Context) {
+		report, err := getHoldingValuations(c.GetString("user_id"), c.Query("portfolio_id"), apiKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+}
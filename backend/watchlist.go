@@ -0,0 +1,350 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errWatchlistEntryNotFound = errors.New("watchlist entry not found")
+
+// WatchlistEntry is a symbol the user tracks but does not necessarily own.
+// TargetHit is set once the latest quote crosses either target: the yield
+// rising to TargetYield or above, or the price falling to TargetPrice or below.
+type WatchlistEntry struct {
+	ID             string     `json:"id" db:"id"`
+	Ticker         string     `json:"ticker" db:"ticker"`
+	Company        string     `json:"company" db:"company"`
+	CurrentPrice   float64    `json:"current_price" db:"current_price"`
+	AnnualDividend float64    `json:"annual_dividend" db:"annual_dividend"`
+	DividendYield  float64    `json:"dividend_yield" db:"dividend_yield"`
+	TargetYield    *float64   `json:"target_yield" db:"target_yield"`
+	TargetPrice    *float64   `json:"target_price" db:"target_price"`
+	TargetHit      bool       `json:"target_hit" db:"target_hit"`
+	TargetHitAt    *time.Time `json:"target_hit_at" db:"target_hit_at"`
+	Notes          string     `json:"notes" db:"notes"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+type CreateWatchlistRequest struct {
+	Ticker      string   `json:"ticker" binding:"required"`
+	TargetYield *float64 `json:"target_yield" binding:"omitempty,gt=0"`
+	TargetPrice *float64 `json:"target_price" binding:"omitempty,gt=0"`
+	Notes       string   `json:"notes"`
+}
+
+type UpdateWatchlistRequest struct {
+	TargetYield *float64 `json:"target_yield" binding:"omitempty,gt=0"`
+	TargetPrice *float64 `json:"target_price" binding:"omitempty,gt=0"`
+	Notes       string   `json:"notes"`
+}
+
+const watchlistColumns = `id, ticker, company, current_price, annual_dividend, dividend_yield, target_yield, target_price, target_hit, target_hit_at, notes, created_at, updated_at`
+
+func scanWatchlistEntry(row rowScanner) (WatchlistEntry, error) {
+	var w WatchlistEntry
+	var targetYield, targetPrice sql.NullFloat64
+	var targetHitAt sql.NullTime
+	err := row.Scan(
+		&w.ID, &w.Ticker, &w.Company, &w.CurrentPrice, &w.AnnualDividend, &w.DividendYield,
+		&targetYield, &targetPrice, &w.TargetHit, &targetHitAt, &w.Notes, &w.CreatedAt, &w.UpdatedAt,
+	)
+	if targetYield.Valid {
+		w.TargetYield = &targetYield.Float64
+	}
+	if targetPrice.Valid {
+		w.TargetPrice = &targetPrice.Float64
+	}
+	if targetHitAt.Valid {
+		w.TargetHitAt = &targetHitAt.Time
+	}
+	return w, err
+}
+
+// watchlistTargetHit reports whether a quote crosses either of the targets.
+func watchlistTargetHit(price, dividendYield float64, targetYield, targetPrice *float64) bool {
+	if targetYield != nil && dividendYield >= *targetYield {
+		return true
+	}
+	if targetPrice != nil && price > 0 && price <= *targetPrice {
+		return true
+	}
+	return false
+}
+
+// targetHitAtSQL keeps the original crossing time while the target stays hit
+// and clears it once the target is no longer crossed. $1 is the new flag.
+const targetHitAtSQL = `CASE WHEN NOT $1::boolean THEN NULL WHEN target_hit THEN target_hit_at ELSE NOW() END`
+
+func getWatchlist(userID string) ([]WatchlistEntry, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot load watchlist")
+	}
+
+	rows, err := db.Query(`SELECT `+watchlistColumns+` FROM watchlist WHERE user_id = $1 ORDER BY ticker`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query watchlist: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []WatchlistEntry
+	for rows.Next() {
+		w, err := scanWatchlistEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan watchlist entry: %v", err)
+		}
+		entries = append(entries, w)
+	}
+
+	return entries, rows.Err()
+}
+
+func createWatchlistEntry(req CreateWatchlistRequest, apiKey string, userID string) (*WatchlistEntry, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot create watchlist entries")
+	}
+
+	ticker := strings.ToUpper(strings.TrimSpace(req.Ticker))
+
+	// A one-share summary gives the per-share price, dividend and yield
+	summary, err := getDividendSummary(ticker, apiKey, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stock data: %v", err)
+	}
+	annualDividend := summary.MonthlyDividend * 12
+	hit := watchlistTargetHit(summary.CurrentPrice, summary.DividendYield, req.TargetYield, req.TargetPrice)
+
+	query := `
+		INSERT INTO watchlist (ticker, company, current_price, annual_dividend, dividend_yield, target_yield, target_price, target_hit, target_hit_at, notes, user_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CASE WHEN $8 THEN NOW() END, $9, $10, NOW(), NOW())
+		RETURNING ` + watchlistColumns + `
+	`
+
+	w, err := scanWatchlistEntry(db.QueryRow(query,
+		summary.Ticker,
+		summary.Company,
+		summary.CurrentPrice,
+		annualDividend,
+		summary.DividendYield,
+		req.TargetYield,
+		req.TargetPrice,
+		hit,
+		req.Notes,
+		userID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert watchlist entry: %v", err)
+	}
+
+	return &w, nil
+}
+
+// updateWatchlistEntry changes the targets and notes of an entry and
+// re-evaluates the trigger against the last stored quote.
+func updateWatchlistEntry(id string, req UpdateWatchlistRequest, userID string) (*WatchlistEntry, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot update watchlist entries")
+	}
+
+	var price, dividendYield float64
+	err := db.QueryRow("SELECT current_price, dividend_yield FROM watchlist WHERE id = $1 AND user_id = $2", id, userID).Scan(&price, &dividendYield)
+	if err == sql.ErrNoRows {
+		return nil, errWatchlistEntryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load watchlist entry: %v", err)
+	}
+
+	hit := watchlistTargetHit(price, dividendYield, req.TargetYield, req.TargetPrice)
+
+	query := `
+		UPDATE watchlist
+		SET target_hit_at = ` + targetHitAtSQL + `, target_hit = $1, target_yield = $2, target_price = $3, notes = $4, updated_at = NOW()
+		WHERE id = $5 AND user_id = $6
+		RETURNING ` + watchlistColumns + `
+	`
+
+	w, err := scanWatchlistEntry(db.QueryRow(query, hit, req.TargetYield, req.TargetPrice, req.Notes, id, userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to update watchlist entry: %v", err)
+	}
+
+	return &w, nil
+}
+
+func deleteWatchlistEntry(id string, userID string) error {
+	if db == nil {
+		return fmt.Errorf("database unavailable - cannot delete watchlist entries")
+	}
+
+	result, err := db.Exec("DELETE FROM watchlist WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete watchlist entry: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return errWatchlistEntryNotFound
+	}
+
+	return nil
+}
+
+// refreshWatchlistEntry pulls a fresh quote and dividend figures for one
+// entry and updates its trigger flag.
+func refreshWatchlistEntry(entry WatchlistEntry, apiKey string, userID string) error {
+	summary, err := getDividendSummary(entry.Ticker, apiKey, 1)
+	if err != nil {
+		return fmt.Errorf("failed to get stock data: %v", err)
+	}
+	hit := watchlistTargetHit(summary.CurrentPrice, summary.DividendYield, entry.TargetYield, entry.TargetPrice)
+
+	query := `
+		UPDATE watchlist
+		SET target_hit_at = ` + targetHitAtSQL + `, target_hit = $1, company = $2, current_price = $3, annual_dividend = $4, dividend_yield = $5, updated_at = NOW()
+		WHERE id = $6 AND user_id = $7
+	`
+
+	_, err = db.Exec(query, hit, summary.Company, summary.CurrentPrice, summary.MonthlyDividend*12, summary.DividendYield, entry.ID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update watchlist entry: %v", err)
+	}
+
+	return nil
+}
+
+func refreshWatchlist(apiKey string, userID string) error {
+	entries, err := getWatchlist(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get watchlist: %v", err)
+	}
+
+	for _, entry := range entries {
+		if err := refreshWatchlistEntry(entry, apiKey, userID); err != nil {
+			fmt.Printf("Warning: failed to refresh watchlist entry %s: %v\n", entry.Ticker, err)
+		}
+	}
+
+	return nil
+}
+
+func registerWatchlistRoutes(r *gin.Engine, apiKey string) {
+	watchlist := r.Group("/watchlist")
+	watchlist.Use(authMiddleware())
+
+	watchlist.GET("", func(c *gin.Context) {
+		entries, err := getWatchlist(c.GetString("user_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, entries)
+	})
+
+	watchlist.GET("/safety", func(c *gin.Context) {
+		report, err := getWatchlistSafety(c.GetString("user_id"), apiKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+
+	watchlist.GET("/valuation", func(c *gin.Context) {
+		report, err := getWatchlistValuations(c.GetString("user_id"), apiKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+
+	watchlist.POST("", func(c *gin.Context) {
+		var req CreateWatchlistRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ticker, ok := validateTicker(c, req.Ticker, apiKey)
+		if !ok {
+			return
+		}
+		req.Ticker = ticker
+
+		entry, err := createWatchlistEntry(req, apiKey, c.GetString("user_id"))
+		if err != nil {
+			if strings.Contains(err.Error(), "duplicate key") {
+				c.JSON(http.StatusConflict, gin.H{"error": "Stock already exists in watchlist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, entry)
+	})
+
+	watchlist.PUT("/:id", func(c *gin.Context) {
+		var req UpdateWatchlistRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		entry, err := updateWatchlistEntry(c.Param("id"), req, c.GetString("user_id"))
+		if err == errWatchlistEntryNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Watchlist entry not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, entry)
+	})
+
+	watchlist.DELETE("/:id", func(c *gin.Context) {
+		err := deleteWatchlistEntry(c.Param("id"), c.GetString("user_id"))
+		if err == errWatchlistEntryNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Watchlist entry not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Watchlist entry deleted successfully"})
+	})
+
+	watchlist.POST("/refresh", func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		if err := refreshWatchlist(apiKey, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		entries, err := getWatchlist(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get updated watchlist"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Watchlist refreshed successfully",
+			"watchlist": entries,
+		})
+	})
+}
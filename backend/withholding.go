@@ -0,0 +1,445 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scopes of a withholding rate override.
+const (
+	WithholdingCountry = "country"
+	WithholdingHolding = "holding"
+)
+
+// defaultWithholdingRates are typical rates, in percent, withheld at
+// source on dividends paid to US investors, keyed by the ISO country code
+// FMP reports. Treaty rates are used where brokers usually apply them at
+// source; countries not listed, including the US, withhold nothing.
+// They are starting points and can be overridden per country or holding.
+var defaultWithholdingRates = map[string]float64{
+	"AU": 30, "BE": 30, "CA": 15, "CH": 35, "CN": 10, "DE": 26.375, "DK": 27,
+	"ES": 19, "FI": 35, "FR": 12.8, "IE": 25, "IL": 25, "IT": 26, "JP": 15.315,
+	"KR": 22, "MX": 10, "NL": 15, "NO": 25, "NZ": 15, "SE": 30, "TW": 21,
+}
+
+// defaultAccountWithholdingRates override defaultWithholdingRates for
+// account types: the US-Canada treaty exempts retirement accounts.
+var defaultAccountWithholdingRates = map[string]map[string]float64{
+	"CA": {"ira": 0, "roth_ira": 0, "401k": 0, "roth_401k": 0},
+}
+
+// WithholdingRate overrides the rate for a country (ISO code) or a
+// holding (ticker), either for every account or, with AccountType, for
+// one account type only.
+type WithholdingRate struct {
+	Scope       string  `json:"scope" binding:"required,oneof=country holding"`
+	Key         string  `json:"key" binding:"required"`
+	AccountType string  `json:"account_type" binding:"omitempty,oneof=taxable ira roth_ira 401k roth_401k hsa other"`
+	Rate        float64 `json:"rate" binding:"min=0,max=100"`
+}
+
+type WithholdingRatesRequest struct {
+	Rates []WithholdingRate `json:"rates" binding:"dive"`
+}
+
+type WithholdingRates struct {
+	Defaults        map[string]float64            `json:"defaults"`
+	AccountDefaults map[string]map[string]float64 `json:"account_defaults"`
+	Rates           []WithholdingRate             `json:"rates"`
+}
+
+// withholdingTable resolves the rate for a holding. The most specific
+// rate wins: holding and account type, holding, country and account type,
+// country, then the built-in defaults.
+type withholdingTable map[string]float64
+
+func withholdingKey(scope, key, accountType string) string {
+	return scope + ":" + key + ":" + accountType
+}
+
+func newWithholdingTable(rates []WithholdingRate) withholdingTable {
+	t := withholdingTable{}
+	for _, r := range rates {
+		t[withholdingKey(r.Scope, r.Key, r.AccountType)] = r.Rate
+	}
+	return t
+}
+
+// rate returns the withholding rate in percent and where it came from.
+func (t withholdingTable) rate(ticker, country, accountType string) (float64, string) {
+	country = strings.ToUpper(country)
+	candidates := []struct{ key, source string }{
+		{withholdingKey(WithholdingHolding, ticker, accountType), "holding_account"},
+		{withholdingKey(WithholdingHolding, ticker, ""), "holding"},
+		{withholdingKey(WithholdingCountry, country, accountType), "country_account"},
+		{withholdingKey(WithholdingCountry, country, ""), "country"},
+	}
+	for _, c := range candidates {
+		if r, ok := t[c.key]; ok {
+			return r, c.source
+		}
+	}
+	if r, ok := defaultAccountWithholdingRates[country][accountType]; ok {
+		return r, "default_account"
+	}
+	if r, ok := defaultWithholdingRates[country]; ok {
+		return r, "default"
+	}
+	return 0, "none"
+}
+
+func getWithholdingRates(userID string) ([]WithholdingRate, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database unavailable - cannot load withholding rates")
+	}
+
+	rows, err := db.Query(`
+		SELECT scope, scope_key, account_type, rate
+		FROM withholding_rates
+		WHERE user_id = $1
+		ORDER BY scope, scope_key, account_type
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query withholding rates: %v", err)
+	}
+	defer rows.Close()
+
+	rates := []WithholdingRate{}
+	for rows.Next() {
+		var r WithholdingRate
+		if err := rows.Scan(&r.Scope, &r.Key, &r.AccountType, &r.Rate); err != nil {
+			return nil, fmt.Errorf("failed to scan withholding rate: %v", err)
+		}
+		rates = append(rates, r)
+	}
+	return rates, rows.Err()
+}
+
+// replaceWithholdingRates swaps the user's overrides for the given set.
+func replaceWithholdingRates(userID string, rates []WithholdingRate) error {
+	if db == nil {
+		return fmt.Errorf("database unavailable - cannot save withholding rates")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin withholding transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM withholding_rates WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to clear withholding rates: %v", err)
+	}
+	for _, r := range rates {
+		_, err := tx.Exec(`
+			INSERT INTO withholding_rates (user_id, scope, scope_key, account_type, rate, updated_at)
+			VALUES ($1, $2, $3, $4, $5, NOW())
+		`, userID, r.Scope, r.Key, r.AccountType, r.Rate)
+		if err != nil {
+			return fmt.Errorf("failed to insert %s withholding rate for %s: %v", r.Scope, r.Key, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DividendAmounts splits dividends into what is withheld at source and
+// what is received.
+type DividendAmounts struct {
+	Gross    float64 `json:"gross"`
+	Withheld float64 `json:"withheld"`
+	Net      float64 `json:"net"`
+}
+
+func withhold(gross, rate float64) DividendAmounts {
+	withheld := gross * rate / 100
+	return DividendAmounts{Gross: gross, Withheld: withheld, Net: gross - withheld}
+}
+
+func (a *DividendAmounts) add(o DividendAmounts) {
+	a.Gross += o.Gross
+	a.Withheld += o.Withheld
+	a.Net += o.Net
+}
+
+func (a *DividendAmounts) round() {
+	a.Gross, a.Withheld, a.Net = roundTo(a.Gross, 2), roundTo(a.Withheld, 2), roundTo(a.Net, 2)
+}
+
+// HoldingIncome is a ticker's dividends in one account. Projected is the
+// annual income of the current shares; Realized is the dividends recorded
+// in the ledger for the report year.
+type HoldingIncome struct {
+	PortfolioID   string          `json:"portfolio_id"`
+	PortfolioName string          `json:"portfolio_name"`
+	AccountType   string          `json:"account_type"`
+	Ticker        string          `json:"ticker"`
+	Country       string          `json:"country"`
+	Rate          float64         `json:"withholding_rate"`
+	RateSource    string          `json:"rate_source"`
+	Projected     DividendAmounts `json:"projected_annual"`
+	Realized      DividendAmounts `json:"realized"`
+}
+
+// IncomeReport gives projected and realized dividends gross, withheld at
+// source, and net, in the base currency. Ledger dividends are taken to be
+// recorded gross.
+type IncomeReport struct {
+	PortfolioID      string          `json:"portfolio_id,omitempty"`
+	Year             int             `json:"year"`
+	BaseCurrency     string          `json:"base_currency"`
+	Rates            []FXRate        `json:"rates"`
+	ProjectedAnnual  DividendAmounts `json:"projected_annual"`
+	ProjectedMonthly DividendAmounts `json:"projected_monthly"`
+	Realized         DividendAmounts `json:"realized"`
+	Holdings         []HoldingIncome `json:"holdings"`
+	Warnings         []string        `json:"warnings"`
+}
+
+// tickerWithholding returns the share of each ticker's projected
+// dividends withheld at source, in percent. A ticker held in accounts of
+// different types averages their rates weighted by income.
+func tickerWithholding(userID string, holdings []PortfolioHolding) (map[string]float64, error) {
+	rates, err := getWithholdingRates(userID)
+	if err != nil {
+		return nil, err
+	}
+	table := newWithholdingTable(rates)
+
+	portfolios, err := getPortfolios(userID)
+	if err != nil {
+		return nil, err
+	}
+	accountTypes := map[string]string{}
+	for _, p := range portfolios {
+		accountTypes[p.ID] = p.AccountType
+	}
+
+	gross, withheld := map[string]float64{}, map[string]float64{}
+	for _, h := range holdings {
+		rate, _ := table.rate(h.Ticker, h.Country, accountTypes[h.PortfolioID])
+		income := h.MonthlyDividend * 12
+		gross[h.Ticker] += income
+		withheld[h.Ticker] += income * rate / 100
+	}
+	result := map[string]float64{}
+	for ticker, g := range gross {
+		if g > 0 {
+			result[ticker] = withheld[ticker] / g * 100
+		}
+	}
+	return result, nil
+}
+
+// tickerCountries returns the country of each ticker the user holds in
+// any portfolio, so tickers only left in the ledger are looked up less.
+func tickerCountries(userID string) (map[string]string, error) {
+	holdings, err := getRawScopedHoldings(userID, "")
+	if err != nil {
+		return nil, err
+	}
+	countries := map[string]string{}
+	for _, h := range holdings {
+		if countries[h.Ticker] == "" {
+			countries[h.Ticker] = h.Country
+		}
+	}
+	return countries, nil
+}
+
+func getIncomeReport(userID, portfolioID string, year int, apiKey string) (*IncomeReport, error) {
+	rates, err := getWithholdingRates(userID)
+	if err != nil {
+		return nil, err
+	}
+	table := newWithholdingTable(rates)
+
+	portfolios, err := getPortfolios(userID)
+	if err != nil {
+		return nil, err
+	}
+	accounts := map[string]Portfolio{}
+	for _, p := range portfolios {
+		accounts[p.ID] = p
+	}
+
+	holdings, conv, err := getBaseHoldings(userID, portfolioID, "")
+	if err != nil {
+		return nil, err
+	}
+	countries, err := tickerCountries(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions, err := getTransactions(userID, TransactionFilter{PortfolioID: portfolioID})
+	if err != nil {
+		return nil, err
+	}
+	lfx, err := newLedgerFX(userID, conv, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if transactions, err = lfx.convertTransactions(transactions); err != nil {
+		return nil, err
+	}
+
+	// Dividends of tickers no longer held need the country from the profile
+	warnings := []string{}
+	prefix := strconv.Itoa(year) + "-"
+	for _, t := range transactions {
+		if t.Type != TransactionDividend || !strings.HasPrefix(t.TradeDate, prefix) {
+			continue
+		}
+		if _, ok := countries[t.Ticker]; ok {
+			continue
+		}
+		country := ""
+		if profile, err := fetchFMPProfile(t.Ticker, apiKey); err == nil {
+			country = profile.Country
+		}
+		countries[t.Ticker] = country
+		if country == "" {
+			warnings = append(warnings, fmt.Sprintf("no country for %s, only holding withholding rates apply", t.Ticker))
+		}
+	}
+
+	type holdingKey struct{ portfolioID, ticker string }
+	lines := map[holdingKey]*HoldingIncome{}
+	line := func(portfolioID, ticker string) *HoldingIncome {
+		k := holdingKey{portfolioID, ticker}
+		if l, ok := lines[k]; ok {
+			return l
+		}
+		account := accounts[portfolioID]
+		l := &HoldingIncome{
+			PortfolioID:   portfolioID,
+			PortfolioName: account.Name,
+			AccountType:   account.AccountType,
+			Ticker:        ticker,
+			Country:       countries[ticker],
+		}
+		l.Rate, l.RateSource = table.rate(ticker, l.Country, l.AccountType)
+		lines[k] = l
+		return l
+	}
+
+	for _, h := range holdings {
+		l := line(h.PortfolioID, h.Ticker)
+		l.Projected.add(withhold(h.MonthlyDividend*12, l.Rate))
+	}
+	for _, t := range transactions {
+		if t.Type != TransactionDividend || !strings.HasPrefix(t.TradeDate, prefix) {
+			continue
+		}
+		l := line(t.PortfolioID, t.Ticker)
+		l.Realized.add(withhold(t.Amount, l.Rate))
+	}
+
+	report := &IncomeReport{
+		PortfolioID:  portfolioID,
+		Year:         year,
+		BaseCurrency: conv.base,
+		Rates:        conv.used,
+		Holdings:     []HoldingIncome{},
+		Warnings:     warnings,
+	}
+	for _, l := range lines {
+		report.ProjectedAnnual.add(l.Projected)
+		report.Realized.add(l.Realized)
+		l.Projected.round()
+		l.Realized.round()
+		report.Holdings = append(report.Holdings, *l)
+	}
+	sort.Slice(report.Holdings, func(i, j int) bool {
+		a, b := report.Holdings[i], report.Holdings[j]
+		if a.PortfolioName != b.PortfolioName {
+			return a.PortfolioName < b.PortfolioName
+		}
+		return a.Ticker < b.Ticker
+	})
+
+	a := report.ProjectedAnnual
+	report.ProjectedMonthly = DividendAmounts{Gross: a.Gross / 12, Withheld: a.Withheld / 12, Net: a.Net / 12}
+	report.ProjectedAnnual.round()
+	report.ProjectedMonthly.round()
+	report.Realized.round()
+	return report, nil
+}
+
+func registerWithholdingRoutes(protected *gin.RouterGroup, apiKey string) {
+	protected.GET("/withholding", func(c *gin.Context) {
+		rates, err := getWithholdingRates(c.GetString("user_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, WithholdingRates{
+			Defaults:        defaultWithholdingRates,
+			AccountDefaults: defaultAccountWithholdingRates,
+			Rates:           rates,
+		})
+	})
+
+	protected.PUT("/withholding", func(c *gin.Context) {
+		var req WithholdingRatesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		rates := req.Rates
+		if rates == nil {
+			rates = []WithholdingRate{}
+		}
+		seen := map[string]bool{}
+		for i := range rates {
+			r := &rates[i]
+			r.Key = strings.ToUpper(strings.TrimSpace(r.Key))
+			if r.Scope == WithholdingHolding {
+				key, err := normalizeSymbol(r.Key)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				r.Key = key
+			}
+			k := withholdingKey(r.Scope, r.Key, r.AccountType)
+			if seen[k] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("duplicate %s withholding rate for %s", r.Scope, r.Key)})
+				return
+			}
+			seen[k] = true
+		}
+
+		if err := replaceWithholdingRates(c.GetString("user_id"), rates); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, rates)
+	})
+
+	protected.GET("/income", func(c *gin.Context) {
+		year := time.Now().Year()
+		if s := c.Query("year"); s != "" {
+			y, err := strconv.Atoi(s)
+			if err != nil || y < 1900 || y > year {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "year must be a past or current calendar year"})
+				return
+			}
+			year = y
+		}
+
+		report, err := getIncomeReport(c.GetString("user_id"), c.Query("portfolio_id"), year, apiKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+}